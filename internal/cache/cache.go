@@ -4,15 +4,83 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
+	"time"
 )
 
-var ErrNotFound = errors.New("cache entry not found")
+var (
+	ErrNotFound = errors.New("cache entry not found")
+	ErrTooLarge = errors.New("cache entry is too large")
+)
 
 type Metadata struct {
-	ETag string `json:"etag,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// StatusCode is the upstream response's status code for the negative
+	// cache entries (e.g. 404 Not Found), with zero meaning 200 OK
+	StatusCode int `json:"status_code,omitempty"`
+
+	// FetchedAt is the time when the cache entry was fetched from the upstream
+	FetchedAt time.Time `json:"fetched_at,omitzero"`
+
+	// FreshnessLifetime is the upstream-provided freshness lifetime
+	// (s-maxage or max-age) of the cache entry
+	FreshnessLifetime time.Duration `json:"freshness_lifetime,omitempty"`
+
+	// StaleIfError is the upstream-provided window (stale-if-error) during which
+	// the stale cache entry can be served when the upstream is not available
+	StaleIfError time.Duration `json:"stale_if_error,omitempty"`
+
+	// Header is a filtered set of the upstream's response headers
+	// (e.g. Content-Type) that are replayed when serving the cache entry
+	Header http.Header `json:"header,omitempty"`
+
+	// Vary is the list of request header fields that the upstream's response
+	// varies on, set only for the cache entries stored under the primary key
+	// that point to the variants stored under the VariantKey
+	Vary []string `json:"vary,omitempty"`
+
+	// SelectingHeader holds the values of the request header fields
+	// listed in Vary that were used to select this variant
+	SelectingHeader http.Header `json:"selecting_header,omitempty"`
 }
 
 type Cache interface {
-	Get(ctx context.Context, key string) (io.ReadCloser, Metadata, error)
-	Put(ctx context.Context, key string, metadata Metadata, blobReader io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, Metadata, error)
+	Head(ctx context.Context, key string) (int64, Metadata, error)
+
+	// Put stores the cache entry, with blobSize being the expected size of
+	// the blob, which allows to reserve the space up front, or -1 if unknown.
+	//
+	// Returns ErrTooLarge when the cache entry can't fit into the cache.
+	Put(ctx context.Context, key string, metadata Metadata, blobReader io.Reader, blobSize int64) error
+}
+
+// Spool is the cache entry that is being written, whose blob can be written
+// at arbitrary offsets (e.g. by the concurrent range requests) and can be read
+// back while it's still being written (e.g. by the concurrent requests).
+type Spool interface {
+	io.ReaderAt
+	io.WriterAt
+
+	// Commit stores the cache entry with the blob of the given size,
+	// which should be fully written by now. The spool can still be
+	// read from after that, until it's closed.
+	//
+	// Returns ErrTooLarge when the cache entry can't fit into the cache.
+	Commit(ctx context.Context, blobSize int64) error
+
+	// Close releases the spool, discarding the cache entry if it's not committed.
+	Close() error
+}
+
+// Spooler is implemented by the caches that can spool the cache entry in place,
+// without writing its blob to a separate temporary file first.
+type Spooler interface {
+	// Spool creates a spool for the cache entry, with blobSize being the expected size
+	// of the blob, which allows to reserve the space up front, or -1 if unknown.
+	//
+	// Returns ErrTooLarge when the cache entry can't fit into the cache.
+	Spool(ctx context.Context, key string, metadata Metadata, blobSize int64) (Spool, error)
 }
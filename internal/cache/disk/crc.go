@@ -0,0 +1,79 @@
+package disk
+
+import (
+	"hash/crc32"
+)
+
+// crc32Combine combines the CRC-32 checksums of two adjacent blocks of data, where
+// the length of the second block is len2, the same way zlib's crc32_combine() does[1].
+//
+// [1]: https://github.com/madler/zlib/blob/v1.3.1/crc32.c
+func crc32Combine(crc1 uint32, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	// Operator for a single zero bit
+	var even, odd [32]uint32
+
+	odd[0] = crc32.IEEE
+
+	row := uint32(1)
+
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	// Operators for two and four zero bits
+	gf2MatrixSquare(&even, &odd)
+	gf2MatrixSquare(&odd, &even)
+
+	// Apply len2 zero bytes to crc1, with the first squaring
+	// producing an operator for one zero byte (eight zero bits)
+	for {
+		gf2MatrixSquare(&even, &odd)
+
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+
+		len2 >>= 1
+
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(&odd, &even)
+
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+
+		len2 >>= 1
+
+		if len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+
+	return sum
+}
+
+func gf2MatrixSquare(square *[32]uint32, mat *[32]uint32) {
+	for n := range 32 {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
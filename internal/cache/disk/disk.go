@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,12 +30,28 @@ type Disk struct {
 	dir        string
 	limitBytes uint64
 	mtx        sync.Mutex
+
+	// reservedBytes is the space reserved by the in-flight Put's
+	// that know their blob size up front
+	reservedBytes uint64
+
+	// namespaceLimits are the limits of the individual namespaces, with
+	// namespaceReservedBytes being the space reserved in these namespaces
+	namespaceLimits        map[string]uint64
+	namespaceReservedBytes map[string]uint64
 }
 
-func New(dir string, limitBytes uint64) (*Disk, error) {
+func New(dir string, limitBytes uint64, opts ...Option) (*Disk, error) {
 	disk := &Disk{
-		dir:        dir,
-		limitBytes: limitBytes,
+		dir:                    dir,
+		limitBytes:             limitBytes,
+		namespaceLimits:        map[string]uint64{},
+		namespaceReservedBytes: map[string]uint64{},
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(disk)
 	}
 
 	// Pre-create the disk's directory if not created yet
@@ -45,7 +62,7 @@ func New(dir string, limitBytes uint64) (*Disk, error) {
 	return disk, nil
 }
 
-func (disk *Disk) Get(_ context.Context, key string) (io.ReadCloser, cache.Metadata, error) {
+func (disk *Disk) Get(_ context.Context, key string) (io.ReadSeekCloser, cache.Metadata, error) {
 	disk.mtx.Lock()
 	defer disk.mtx.Unlock()
 
@@ -59,19 +76,10 @@ func (disk *Disk) Get(_ context.Context, key string) (io.ReadCloser, cache.Metad
 		return nil, cache.Metadata{}, fmt.Errorf("failed to open cache entry %q: %w", key, err)
 	}
 
-	// Update the access and modification times so that eviction would work correctly
-	now := time.Now()
-
-	if err := os.Chtimes(disk.path(key), now, now); err != nil {
+	if err := disk.touch(key); err != nil {
 		_ = cacheFile.Close()
 
-		// Convert the error for consumer's convenience
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, cache.Metadata{}, cache.ErrNotFound
-		}
-
-		return nil, cache.Metadata{}, fmt.Errorf("failed to set access and modification times "+
-			" for the cache entry %q: %w", key, err)
+		return nil, cache.Metadata{}, err
 	}
 
 	reader, info, err := disk.getInner(cacheFile)
@@ -84,66 +92,70 @@ func (disk *Disk) Get(_ context.Context, key string) (io.ReadCloser, cache.Metad
 	return reader, info.Metadata, nil
 }
 
-func (disk *Disk) Put(_ context.Context, key string, metadata cache.Metadata, blobReader io.Reader) error {
-	tmpFile, err := os.CreateTemp("", "chacha-put-*")
-	if err != nil {
-		return fmt.Errorf("failed to create a temporary file for the cache entry %q: %w",
-			key, err)
-	}
+// Head retrieves the cache entry's size and metadata without opening its blob.
+func (disk *Disk) Head(_ context.Context, key string) (int64, cache.Metadata, error) {
+	disk.mtx.Lock()
+	defer disk.mtx.Unlock()
 
-	// Write the cache entry as a ZIP file
-	zipWriter := zip.NewWriter(tmpFile)
+	cacheFile, err := os.Open(disk.path(key))
+	if err != nil {
+		// Convert the error for consumer's convenience
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, cache.Metadata{}, cache.ErrNotFound
+		}
 
-	// Write cache entry's info
-	if err := writeInfo(zipWriter, Info{
-		Key:      key,
-		Metadata: metadata,
-	}); err != nil {
-		_ = tmpFile.Close()
-		_ = os.Remove(tmpFile.Name())
+		return 0, cache.Metadata{}, fmt.Errorf("failed to open cache entry %q: %w", key, err)
+	}
+	defer cacheFile.Close()
 
-		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
-			fileInfo, key, err)
+	// Existence checks (e.g. the Bazel's HEAD requests) count as a use too
+	if err := disk.touch(key); err != nil {
+		return 0, cache.Metadata{}, err
 	}
 
-	// Acquire a handle to the cache entry's underlying blob
-	blobWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
-		Name:   fileBlob,
-		Method: zip.Store,
-	})
+	reader, info, err := disk.getInner(cacheFile)
 	if err != nil {
-		_ = tmpFile.Close()
-		_ = os.Remove(tmpFile.Name())
+		return 0, cache.Metadata{}, fmt.Errorf("failed to read cache entry %q: %w", key, err)
+	}
 
-		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
-			fileBlob, key, err)
+	return reader.sectionReader.Size(), info.Metadata, nil
+}
+
+func (disk *Disk) Put(
+	ctx context.Context,
+	key string,
+	metadata cache.Metadata,
+	blobReader io.Reader,
+	blobSize int64,
+) error {
+	spool, err := disk.Spool(ctx, key, metadata, blobSize)
+	if err != nil {
+		return err
 	}
 
-	if _, err := io.Copy(blobWriter, blobReader); err != nil {
-		_ = tmpFile.Close()
-		_ = os.Remove(tmpFile.Name())
+	n, err := io.Copy(io.NewOffsetWriter(spool, 0), blobReader)
+	if err != nil {
+		_ = spool.Close()
 
 		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
 			fileBlob, key, err)
 	}
 
-	if err := zipWriter.Close(); err != nil {
-		_ = tmpFile.Close()
-		_ = os.Remove(tmpFile.Name())
+	if blobSize >= 0 && n != blobSize {
+		_ = spool.Close()
 
-		return fmt.Errorf("failed to finalize cache entry %q: %w", key, err)
+		return fmt.Errorf("failed to write %q file to the cache entry %q: expected %d bytes, got %d bytes",
+			fileBlob, key, blobSize, n)
 	}
 
-	if err := tmpFile.Close(); err != nil {
-		_ = os.Remove(tmpFile.Name())
+	if err := spool.Commit(ctx, n); err != nil {
+		_ = spool.Close()
 
-		return fmt.Errorf("failed to close cache entry %q: %w", key, err)
+		return err
 	}
 
-	if err := disk.accept(key, tmpFile.Name()); err != nil {
-		_ = os.Remove(tmpFile.Name())
-
-		return fmt.Errorf("failed to accept cache entry %q: %w", key, err)
+	if err := spool.Close(); err != nil {
+		return fmt.Errorf("failed to close cache entry %q: %w", key, err)
 	}
 
 	return nil
@@ -165,7 +177,18 @@ func (disk *Disk) Walk(walkFunc WalkFunc) error {
 			continue
 		}
 
-		if err := walkFunc(disk.getInner(cacheFile)); err != nil {
+		reader, info, err := disk.getInner(cacheFile)
+		if err != nil {
+			_ = cacheFile.Close()
+
+			if err := walkFunc(nil, Info{}, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := walkFunc(reader, info, nil); err != nil {
 			return err
 		}
 	}
@@ -186,18 +209,74 @@ func (disk *Disk) Delete(key string) error {
 		return err
 	}
 
+	// Deleting the primary key also deletes all of its variants
+	if _, secondaryKey := cache.SplitKey(key); secondaryKey == "" {
+		variantPaths, err := filepath.Glob(disk.path(key) + "-*")
+		if err != nil {
+			return err
+		}
+
+		for _, variantPath := range variantPaths {
+			if err := os.Remove(variantPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// touch updates the cache entry's access and modification times so that eviction would work correctly.
+func (disk *Disk) touch(key string) error {
+	now := time.Now()
+
+	if err := os.Chtimes(disk.path(key), now, now); err != nil {
+		// Convert the error for consumer's convenience
+		if errors.Is(err, os.ErrNotExist) {
+			return cache.ErrNotFound
+		}
+
+		return fmt.Errorf("failed to set access and modification times "+
+			" for the cache entry %q: %w", key, err)
+	}
+
 	return nil
 }
 
 func (disk *Disk) path(key string) string {
 	// On macOS, the maximum filename length is 255 characters (inclusive),
 	// so the safest way to avoid errors is to hash the cache entry's key
-	hash := sha256.Sum256([]byte(key))
+	//
+	// Variants are stored next to their primary key
+	// by sharing the primary key's hash as a prefix.
+	primaryKey, secondaryKey := cache.SplitKey(key)
 
-	return filepath.Join(disk.dir, hex.EncodeToString(hash[:]))
+	name := namespacePrefix(cache.KeyNamespace(key)) + hashHex(primaryKey)
+
+	if secondaryKey != "" {
+		name += "-" + hashHex(secondaryKey)
+	}
+
+	return filepath.Join(disk.dir, name)
 }
 
-func (disk *Disk) getInner(cacheFile *os.File) (fs.File, Info, error) {
+// namespacePrefix returns the prefix of the cache entries' names in the given namespace,
+// which allows to determine the namespace's usage without opening the cache entries.
+func namespacePrefix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+
+	return hashHex(namespace)[:16] + "_"
+}
+
+func hashHex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func (disk *Disk) getInner(cacheFile *os.File) (*Reader, Info, error) {
 	// Open the cache entry as a ZIP file
 	fi, err := cacheFile.Stat()
 	if err != nil {
@@ -221,28 +300,70 @@ func (disk *Disk) getInner(cacheFile *os.File) (fs.File, Info, error) {
 	}
 
 	// Acquire a handle to the cache entry's underlying blob
-	blobReader, err := zipReader.Open(fileBlob)
+	blobFile, ok := lo.Find(zipReader.File, func(file *zip.File) bool {
+		return file.Name == fileBlob
+	})
+	if !ok {
+		return nil, Info{}, fmt.Errorf("failed to read from ZIP file: %q file is missing", fileBlob)
+	}
+
+	// The blob is always written uncompressed, which allows us
+	// to access it directly in the underlying file and to seek
+	if blobFile.Method != zip.Store {
+		return nil, Info{}, fmt.Errorf("failed to read from ZIP file: %q file uses "+
+			"an unsupported compression method %d", fileBlob, blobFile.Method)
+	}
+
+	dataOffset, err := blobFile.DataOffset()
 	if err != nil {
 		return nil, Info{}, fmt.Errorf("failed to read from ZIP file: %w", err)
 	}
 
 	return &Reader{
-		cacheFile:  cacheFile,
-		blobReader: blobReader,
+		cacheFile:     cacheFile,
+		blobInfo:      blobFile.FileInfo(),
+		sectionReader: io.NewSectionReader(cacheFile, dataOffset, int64(blobFile.UncompressedSize64)),
 	}, *info, nil
 }
 
-func (disk *Disk) accept(key string, path string) error {
+func (disk *Disk) reserve(namespace string, needBytes uint64) error {
+	disk.mtx.Lock()
+	defer disk.mtx.Unlock()
+
+	if err := disk.evict(namespace, needBytes); err != nil {
+		return err
+	}
+
+	disk.reservedBytes += needBytes
+	disk.namespaceReservedBytes[namespace] += needBytes
+
+	return nil
+}
+
+func (disk *Disk) unreserve(namespace string, reservedBytes uint64) {
+	disk.mtx.Lock()
+	defer disk.mtx.Unlock()
+
+	disk.reservedBytes -= reservedBytes
+	disk.namespaceReservedBytes[namespace] -= reservedBytes
+
+	if disk.namespaceReservedBytes[namespace] == 0 {
+		delete(disk.namespaceReservedBytes, namespace)
+	}
+}
+
+func (disk *Disk) accept(key string, namespace string, path string, reservedBytes uint64) error {
 	disk.mtx.Lock()
 	defer disk.mtx.Unlock()
 
-	// Prepare for accepting the new cache entry
+	// Prepare for accepting the new cache entry,
+	// taking into account the already reserved space
 	fi, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
 
-	if err := disk.evict(uint64(fi.Size())); err != nil {
+	if err := disk.evict(namespace, uint64(fi.Size())-min(uint64(fi.Size()), reservedBytes)); err != nil {
 		return err
 	}
 
@@ -250,11 +371,19 @@ func (disk *Disk) accept(key string, path string) error {
 	return os.Rename(path, disk.path(key))
 }
 
-func (disk *Disk) evict(needBytes uint64) error {
+func (disk *Disk) evict(namespace string, needBytes uint64) error {
 	// Does it even make sense to evict anything?
 	if needBytes > disk.limitBytes {
-		return fmt.Errorf("cannot accept cache entry as it's size of %d bytes"+
-			" is larger than the disk limit of %d bytes", needBytes, disk.limitBytes)
+		return fmt.Errorf("%w: cannot accept cache entry as it's size of %d bytes"+
+			" is larger than the disk limit of %d bytes", cache.ErrTooLarge, needBytes, disk.limitBytes)
+	}
+
+	namespaceLimitBytes, hasNamespaceLimit := disk.namespaceLimits[namespace]
+
+	if hasNamespaceLimit && needBytes > namespaceLimitBytes {
+		return fmt.Errorf("%w: cannot accept cache entry as it's size of %d bytes is larger"+
+			" than the %q namespace limit of %d bytes", cache.ErrTooLarge, needBytes, namespace,
+			namespaceLimitBytes)
 	}
 
 	// Collect a slice of cache entries, sorted by modification time, ascending order
@@ -262,6 +391,7 @@ func (disk *Disk) evict(needBytes uint64) error {
 		Name    string
 		Size    uint64
 		ModTime time.Time
+		Evicted bool
 	}
 
 	var entries []*Entry
@@ -288,21 +418,74 @@ func (disk *Disk) evict(needBytes uint64) error {
 		return a.ModTime.Compare(b.ModTime)
 	})
 
-	usedBytes := lo.SumBy(entries, func(entry *Entry) uint64 {
-		return entry.Size
-	})
+	// Evict the oldest entries to fit the new entry into the limit,
+	// returning the resulting used space
+	evictOldest := func(entries []*Entry, usedBytes uint64, limitBytes uint64) (uint64, error) {
+		for _, entry := range entries {
+			if (usedBytes + needBytes) <= limitBytes {
+				break
+			}
 
-	// Evict the oldest entries to fit the new entry
-	for _, entry := range entries {
-		if (usedBytes + needBytes) <= disk.limitBytes {
-			return nil
+			if entry.Evicted {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(disk.dir, entry.Name)); err != nil {
+				return usedBytes, err
+			}
+
+			entry.Evicted = true
+			usedBytes -= entry.Size
 		}
 
-		if err := os.Remove(filepath.Join(disk.dir, entry.Name)); err != nil {
+		return usedBytes, nil
+	}
+
+	// Evict the oldest entries of the namespace first to fit the new entry into the namespace's limit,
+	// accounting for the space reserved in this namespace by the in-flight Put's
+	if hasNamespaceLimit {
+		namespaceEntries := lo.Filter(entries, func(entry *Entry, _ int) bool {
+			return strings.HasPrefix(entry.Name, namespacePrefix(namespace))
+		})
+
+		usedBytes := lo.SumBy(namespaceEntries, func(entry *Entry) uint64 {
+			return entry.Size
+		}) + disk.namespaceReservedBytes[namespace]
+
+		usedBytes, err := evictOldest(namespaceEntries, usedBytes, namespaceLimitBytes)
+		if err != nil {
 			return err
 		}
 
-		usedBytes -= entry.Size
+		// The space reserved by the in-flight Put's can't be evicted
+		if (usedBytes + needBytes) > namespaceLimitBytes {
+			return fmt.Errorf("%w: cannot accept cache entry of %d bytes as %d bytes are reserved "+
+				"by the other cache entries in the %q namespace", cache.ErrTooLarge, needBytes,
+				disk.namespaceReservedBytes[namespace], namespace)
+		}
+	}
+
+	usedBytes := lo.SumBy(entries, func(entry *Entry) uint64 {
+		if entry.Evicted {
+			return 0
+		}
+
+		return entry.Size
+	})
+
+	// Account for the space reserved by the in-flight Put's
+	usedBytes += disk.reservedBytes
+
+	// Evict the oldest entries to fit the new entry
+	usedBytes, err = evictOldest(entries, usedBytes, disk.limitBytes)
+	if err != nil {
+		return err
+	}
+
+	// The space reserved by the in-flight Put's can't be evicted
+	if (usedBytes + needBytes) > disk.limitBytes {
+		return fmt.Errorf("%w: cannot accept cache entry of %d bytes as %d bytes "+
+			"are reserved by the other cache entries", cache.ErrTooLarge, needBytes, disk.reservedBytes)
 	}
 
 	return nil
@@ -1,6 +1,7 @@
 package disk_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	cachepkg "github.com/cirruslabs/chacha/internal/cache"
@@ -9,7 +10,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
 )
 
 func TestSimple(t *testing.T) {
@@ -31,7 +34,7 @@ func TestSimple(t *testing.T) {
 
 	err = cache.Put(ctx, "test", cachepkg.Metadata{
 		ETag: eTag,
-	}, bytes.NewReader(contentBytes))
+	}, bytes.NewReader(contentBytes), -1)
 	require.NoError(t, err)
 
 	// Retrieval of an existent key should succeed
@@ -45,7 +48,7 @@ func TestSimple(t *testing.T) {
 	// Re-insertion of an existent key should succeed
 	newContentsBytes := []byte("Bye bye!")
 
-	err = cache.Put(ctx, "test", cachepkg.Metadata{}, bytes.NewReader(newContentsBytes))
+	err = cache.Put(ctx, "test", cachepkg.Metadata{}, bytes.NewReader(newContentsBytes), -1)
 	require.NoError(t, err)
 
 	// Retrieval of a re-inserted key should yield modified contents
@@ -71,10 +74,10 @@ func TestEvict(t *testing.T) {
 	require.NoError(t, err)
 
 	// Eviction shouldn't occur if cache entries fit the budget
-	err = cache.Put(ctx, "small1", cachepkg.Metadata{}, bytes.NewReader([]byte("ab")))
+	err = cache.Put(ctx, "small1", cachepkg.Metadata{}, bytes.NewReader([]byte("ab")), -1)
 	require.NoError(t, err)
 
-	err = cache.Put(ctx, "small2", cachepkg.Metadata{}, bytes.NewReader([]byte("cde")))
+	err = cache.Put(ctx, "small2", cachepkg.Metadata{}, bytes.NewReader([]byte("cde")), -1)
 	require.NoError(t, err)
 
 	_, _, err = cache.Get(ctx, "small1")
@@ -84,7 +87,7 @@ func TestEvict(t *testing.T) {
 	require.NoError(t, err)
 
 	// Eviction should occur for oldest entry if the budget is violated
-	err = cache.Put(ctx, "small3", cachepkg.Metadata{}, bytes.NewReader([]byte("f")))
+	err = cache.Put(ctx, "small3", cachepkg.Metadata{}, bytes.NewReader([]byte("f")), -1)
 	require.NoError(t, err)
 
 	_, _, err = cache.Get(ctx, "small1")
@@ -97,6 +100,32 @@ func TestEvict(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEvictAfterHead(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 768)
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, "small1", cachepkg.Metadata{}, bytes.NewReader([]byte("ab")), -1)
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, "small2", cachepkg.Metadata{}, bytes.NewReader([]byte("cde")), -1)
+	require.NoError(t, err)
+
+	// Checking the cache entry's existence counts as a use
+	_, _, err = cache.Head(ctx, "small1")
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, "small3", cachepkg.Metadata{}, bytes.NewReader([]byte("f")), -1)
+	require.NoError(t, err)
+
+	_, _, err = cache.Head(ctx, "small1")
+	require.NoError(t, err)
+
+	_, _, err = cache.Head(ctx, "small2")
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+}
+
 func TestSecure(t *testing.T) {
 	ctx := context.Background()
 
@@ -106,7 +135,7 @@ func TestSecure(t *testing.T) {
 
 	// Ensure that insecure keys are percent-encoded
 	err = cache.Put(ctx, "../../../../../etc/passwd", cachepkg.Metadata{},
-		bytes.NewReader([]byte("doesn't matter")))
+		bytes.NewReader([]byte("doesn't matter")), -1)
 	require.NoError(t, err)
 
 	dirEntries, err := os.ReadDir(cacheDir)
@@ -120,3 +149,255 @@ func TestSecure(t *testing.T) {
 
 	require.Regexp(t, "[A-Za-z0-9]+", dirEntryNames)
 }
+
+func TestSeek(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 1*1024*1024)
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, "test", cachepkg.Metadata{}, bytes.NewReader([]byte("Hello, World!")), -1)
+	require.NoError(t, err)
+
+	retrievalReader, _, err := cache.Get(ctx, "test")
+	require.NoError(t, err)
+
+	// Seeking to the end should yield the size of the cache entry
+	size, err := retrievalReader.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, 13, size)
+
+	// Seeking to the middle should yield the rest of the cache entry
+	_, err = retrievalReader.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+
+	retrievedContentBytes, err := io.ReadAll(retrievalReader)
+	require.NoError(t, err)
+	require.Equal(t, "World!", string(retrievedContentBytes))
+
+	require.NoError(t, retrievalReader.Close())
+}
+
+func TestHead(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 1*1024*1024)
+	require.NoError(t, err)
+
+	// Retrieval of a non-existent key should fail
+	_, _, err = cache.Head(ctx, "test")
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+
+	// Retrieval of an existent key should yield its size and metadata
+	eTag := uuid.NewString()
+
+	err = cache.Put(ctx, "test", cachepkg.Metadata{
+		ETag: eTag,
+	}, bytes.NewReader([]byte("Hello, World!")), -1)
+	require.NoError(t, err)
+
+	size, metadata, err := cache.Head(ctx, "test")
+	require.NoError(t, err)
+	require.EqualValues(t, 13, size)
+	require.Equal(t, eTag, metadata.ETag)
+}
+
+func TestVariants(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 1*1024*1024)
+	require.NoError(t, err)
+
+	primaryKey := "https://example.com/"
+	variantKeyA := cachepkg.VariantKey(primaryKey, "origin=a")
+	variantKeyB := cachepkg.VariantKey(primaryKey, "origin=b")
+
+	// Store the primary key and its variants
+	err = cache.Put(ctx, primaryKey, cachepkg.Metadata{
+		Vary: []string{"Origin"},
+	}, bytes.NewReader(nil), -1)
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, variantKeyA, cachepkg.Metadata{}, bytes.NewReader([]byte("a")), -1)
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, variantKeyB, cachepkg.Metadata{}, bytes.NewReader([]byte("b")), -1)
+	require.NoError(t, err)
+
+	// Variants should be retrievable independently
+	for variantKey, expectedContents := range map[string]string{
+		variantKeyA: "a",
+		variantKeyB: "b",
+	} {
+		retrievalReader, _, err := cache.Get(ctx, variantKey)
+		require.NoError(t, err)
+
+		retrievedContentBytes, err := io.ReadAll(retrievalReader)
+		require.NoError(t, err)
+		require.Equal(t, expectedContents, string(retrievedContentBytes))
+		require.NoError(t, retrievalReader.Close())
+	}
+
+	_, metadata, err := cache.Get(ctx, primaryKey)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Origin"}, metadata.Vary)
+
+	// Deleting the primary key should delete all of its variants
+	require.NoError(t, cache.Delete(primaryKey))
+
+	_, _, err = cache.Get(ctx, variantKeyA)
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+
+	_, _, err = cache.Get(ctx, variantKeyB)
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+}
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	// Cache entries larger than the limit should be rejected up front
+	err = cache.Put(ctx, "huge", cachepkg.Metadata{}, iotest.ErrReader(io.ErrUnexpectedEOF), 2048)
+	require.ErrorIs(t, err, cachepkg.ErrTooLarge)
+
+	// Cache entries that don't fit due to the space reserved
+	// by the in-flight cache entries should be rejected up front
+	pipeReader, pipeWriter := io.Pipe()
+
+	firstPutErrCh := make(chan error, 1)
+
+	go func() {
+		firstPutErrCh <- cache.Put(ctx, "first", cachepkg.Metadata{}, pipeReader, 600)
+	}()
+
+	_, err = pipeWriter.Write(bytes.Repeat([]byte("A"), 300))
+	require.NoError(t, err)
+
+	err = cache.Put(ctx, "second", cachepkg.Metadata{}, iotest.ErrReader(io.ErrUnexpectedEOF), 600)
+	require.ErrorIs(t, err, cachepkg.ErrTooLarge)
+
+	_, err = pipeWriter.Write(bytes.Repeat([]byte("A"), 300))
+	require.NoError(t, err)
+	require.NoError(t, pipeWriter.Close())
+	require.NoError(t, <-firstPutErrCh)
+
+	// Cache entries that don't match the expected size should be rejected
+	err = cache.Put(ctx, "mismatch", cachepkg.Metadata{}, bytes.NewReader([]byte("Hello")), 6)
+	require.Error(t, err)
+
+	_, _, err = cache.Get(ctx, "mismatch")
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+}
+
+func TestNamespaceLimit(t *testing.T) {
+	ctx := context.Background()
+
+	cache, err := disk.New(t.TempDir(), 1024*1024, disk.WithNamespaceLimit("limited", 1024))
+	require.NoError(t, err)
+
+	unlimitedKey := cachepkg.NamespacedKey("unlimited", "1")
+	firstKey := cachepkg.NamespacedKey("limited", "1")
+	secondKey := cachepkg.NamespacedKey("limited", "2")
+
+	require.NoError(t, cache.Put(ctx, "https://example.com/", cachepkg.Metadata{},
+		bytes.NewReader(bytes.Repeat([]byte("A"), 600)), 600))
+	require.NoError(t, cache.Put(ctx, unlimitedKey, cachepkg.Metadata{},
+		bytes.NewReader(bytes.Repeat([]byte("A"), 600)), 600))
+
+	// Cache entries larger than the namespace limit should be rejected up front
+	err = cache.Put(ctx, firstKey, cachepkg.Metadata{}, iotest.ErrReader(io.ErrUnexpectedEOF), 2048)
+	require.ErrorIs(t, err, cachepkg.ErrTooLarge)
+
+	// Exceeding the namespace limit should only evict the namespace's entries
+	require.NoError(t, cache.Put(ctx, firstKey, cachepkg.Metadata{},
+		bytes.NewReader(bytes.Repeat([]byte("A"), 300)), 300))
+	require.NoError(t, cache.Put(ctx, secondKey, cachepkg.Metadata{},
+		bytes.NewReader(bytes.Repeat([]byte("A"), 300)), 300))
+
+	_, _, err = cache.Get(ctx, firstKey)
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+
+	for _, key := range []string{"https://example.com/", unlimitedKey, secondKey} {
+		_, _, err = cache.Get(ctx, key)
+		require.NoError(t, err)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	cache, err := disk.New(dir, 1024*1024)
+	require.NoError(t, err)
+
+	contentBytes := bytes.Repeat([]byte("Hello, World!"), 1000)
+
+	spool, err := cache.Spool(ctx, "test", cachepkg.Metadata{ETag: "v1"}, int64(len(contentBytes)))
+	require.NoError(t, err)
+
+	// Write the blob out of order, as the concurrent range requests would do
+	for _, segment := range [][2]int{{8000, 13000}, {0, 3000}, {5000, 8000}, {3000, 5000}} {
+		_, err := spool.WriteAt(contentBytes[segment[0]:segment[1]], int64(segment[0]))
+		require.NoError(t, err)
+	}
+
+	// The blob should be readable before committing
+	readBytes := make([]byte, len(contentBytes))
+	_, err = spool.ReadAt(readBytes, 0)
+	require.NoError(t, err)
+	require.Equal(t, contentBytes, readBytes)
+
+	_, _, err = cache.Get(ctx, "test")
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+
+	require.NoError(t, spool.Commit(ctx, int64(len(contentBytes))))
+
+	// The blob should still be readable after committing, until closed
+	_, err = spool.ReadAt(readBytes, 0)
+	require.NoError(t, err)
+	require.Equal(t, contentBytes, readBytes)
+	require.NoError(t, spool.Close())
+
+	retrievalReader, metadata, err := cache.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, "v1", metadata.ETag)
+
+	retrievedBytes, err := io.ReadAll(retrievalReader)
+	require.NoError(t, err)
+	require.NoError(t, retrievalReader.Close())
+	require.Equal(t, contentBytes, retrievedBytes)
+
+	// The cache entry should be a valid ZIP file, with the blob's CRC-32 matching
+	dirEntries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, dirEntries, 1)
+
+	zipReader, err := zip.OpenReader(filepath.Join(dir, dirEntries[0].Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, zipReader.Close())
+	})
+
+	blobReader, err := zipReader.Open("blob.bin")
+	require.NoError(t, err)
+
+	retrievedBytes, err = io.ReadAll(blobReader)
+	require.NoError(t, err)
+	require.Equal(t, contentBytes, retrievedBytes)
+
+	// Spool that is not written completely can't be committed
+	// and should not leave anything behind once closed
+	spool, err = cache.Spool(ctx, "incomplete", cachepkg.Metadata{}, -1)
+	require.NoError(t, err)
+
+	_, err = spool.WriteAt(contentBytes[100:200], 100)
+	require.NoError(t, err)
+	require.Error(t, spool.Commit(ctx, 200))
+	require.NoError(t, spool.Close())
+
+	_, _, err = cache.Get(ctx, "incomplete")
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+}
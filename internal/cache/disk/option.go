@@ -0,0 +1,14 @@
+package disk
+
+type Option func(disk *Disk)
+
+// WithNamespaceLimit limits the size of the cache entries with namespaced keys
+// (see cache.NamespacedKey) in the given namespace, with the least recently
+// accessed entries of that namespace being evicted first to fit the limit.
+//
+// The limit is a part of the disk's overall limit, not in addition to it.
+func WithNamespaceLimit(namespace string, limitBytes uint64) Option {
+	return func(disk *Disk) {
+		disk.namespaceLimits[namespace] = limitBytes
+	}
+}
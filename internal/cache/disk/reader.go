@@ -1,27 +1,29 @@
 package disk
 
 import (
+	"io"
 	"io/fs"
 	"os"
 )
 
 type Reader struct {
-	cacheFile  *os.File
-	blobReader fs.File
+	cacheFile     *os.File
+	blobInfo      fs.FileInfo
+	sectionReader *io.SectionReader
 }
 
 func (entry *Reader) Stat() (fs.FileInfo, error) {
-	return entry.blobReader.Stat()
+	return entry.blobInfo, nil
 }
 
 func (entry *Reader) Read(p []byte) (int, error) {
-	return entry.blobReader.Read(p)
+	return entry.sectionReader.Read(p)
 }
 
-func (entry *Reader) Close() error {
-	if err := entry.blobReader.Close(); err != nil {
-		return err
-	}
+func (entry *Reader) Seek(offset int64, whence int) (int64, error) {
+	return entry.sectionReader.Seek(offset, whence)
+}
 
+func (entry *Reader) Close() error {
 	return entry.cacheFile.Close()
 }
@@ -0,0 +1,303 @@
+package disk
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cirruslabs/chacha/internal/cache"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+)
+
+// zipFlagDataDescriptor tells that the ZIP file's CRC-32 and sizes
+// follow its contents in a data descriptor instead of the local header[1],
+// which allows us to write the local header before the contents are known.
+//
+// [1]: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+const zipFlagDataDescriptor = 0x8
+
+// Spool is the cache entry that is being written, whose blob is written in place,
+// directly into the cache entry's ZIP file, with the rest of the ZIP file being
+// written once committed.
+type Spool struct {
+	disk          *Disk
+	key           string
+	namespace     string
+	reservedBytes uint64
+
+	file       *os.File
+	fileWriter *spoolFileWriter
+	zipWriter  *zip.Writer
+	blobHeader *zip.FileHeader
+	blobWriter io.Writer
+	blobOffset int64
+
+	mtx       sync.Mutex
+	crcRanges []crcRange
+	committed bool
+}
+
+// crcRange is the contiguous range of the blob that was written, along with its CRC-32.
+type crcRange struct {
+	start int64
+	end   int64
+	crc   uint32
+}
+
+// Spool creates a spool for the cache entry, see cache.Spooler.
+func (disk *Disk) Spool(_ context.Context, key string, metadata cache.Metadata, blobSize int64) (cache.Spool, error) {
+	namespace := cache.KeyNamespace(key)
+
+	// Reserve the space up front when the blob size is known,
+	// so that the concurrent Put's won't overshoot the limit
+	var reservedBytes uint64
+
+	if blobSize >= 0 {
+		reservedBytes = uint64(blobSize)
+
+		if err := disk.reserve(namespace, reservedBytes); err != nil {
+			return nil, fmt.Errorf("failed to reserve space for the cache entry %q: %w", key, err)
+		}
+	}
+
+	file, err := os.CreateTemp("", "chacha-put-*")
+	if err != nil {
+		disk.unreserve(namespace, reservedBytes)
+
+		return nil, fmt.Errorf("failed to create a temporary file for the cache entry %q: %w",
+			key, err)
+	}
+
+	spool := &Spool{
+		disk:          disk,
+		key:           key,
+		namespace:     namespace,
+		reservedBytes: reservedBytes,
+		file:          file,
+		fileWriter:    &spoolFileWriter{file: file},
+	}
+
+	if err := spool.writePrefix(metadata); err != nil {
+		_ = spool.Close()
+
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+// writePrefix writes everything that precedes the blob in the cache entry's ZIP file.
+func (spool *Spool) writePrefix(metadata cache.Metadata) error {
+	spool.zipWriter = zip.NewWriter(spool.fileWriter)
+
+	// Write cache entry's info
+	if err := writeInfo(spool.zipWriter, Info{
+		Key:      spool.key,
+		Metadata: metadata,
+	}); err != nil {
+		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
+			fileInfo, spool.key, err)
+	}
+
+	// Write the blob's local header, with its CRC-32 and sizes
+	// being written in the data descriptor once committed
+	spool.blobHeader = &zip.FileHeader{
+		Name:   fileBlob,
+		Method: zip.Store,
+		Flags:  zipFlagDataDescriptor,
+	}
+
+	blobWriter, err := spool.zipWriter.CreateRaw(spool.blobHeader)
+	if err != nil {
+		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
+			fileBlob, spool.key, err)
+	}
+
+	if err := spool.zipWriter.Flush(); err != nil {
+		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
+			fileBlob, spool.key, err)
+	}
+
+	spool.blobWriter = blobWriter
+	spool.blobOffset = spool.fileWriter.offset
+
+	return nil
+}
+
+func (spool *Spool) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("failed to read from the cache entry %q: negative offset", spool.key)
+	}
+
+	return spool.file.ReadAt(p, spool.blobOffset+off)
+}
+
+// WriteAt writes the blob's contents at the given offset. The writes
+// may come in any order, but must not overlap.
+func (spool *Spool) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("failed to write to the cache entry %q: negative offset", spool.key)
+	}
+
+	n, err := spool.file.WriteAt(p, spool.blobOffset+off)
+
+	spool.mtx.Lock()
+	spool.checksum(p[:n], off)
+	spool.mtx.Unlock()
+
+	return n, err
+}
+
+// checksum updates the CRC-32 of the written ranges with the contents written
+// at the given offset, combining the ranges once they become adjacent.
+func (spool *Spool) checksum(p []byte, off int64) {
+	if len(p) == 0 {
+		return
+	}
+
+	end := off + int64(len(p))
+
+	// Extend the range that ends where the contents
+	// start or insert a new range otherwise
+	i := slices.IndexFunc(spool.crcRanges, func(crcRange crcRange) bool {
+		return crcRange.end == off
+	})
+	if i >= 0 {
+		spool.crcRanges[i].crc = crc32.Update(spool.crcRanges[i].crc, crc32.IEEETable, p)
+		spool.crcRanges[i].end = end
+	} else {
+		i, _ = slices.BinarySearchFunc(spool.crcRanges, off, func(crcRange crcRange, off int64) int {
+			return cmp.Compare(crcRange.start, off)
+		})
+
+		spool.crcRanges = slices.Insert(spool.crcRanges, i, crcRange{
+			start: off,
+			end:   end,
+			crc:   crc32.ChecksumIEEE(p),
+		})
+	}
+
+	// Merge with the next range if it's adjacent now
+	if i+1 < len(spool.crcRanges) && spool.crcRanges[i+1].start == spool.crcRanges[i].end {
+		next := spool.crcRanges[i+1]
+
+		spool.crcRanges[i].crc = crc32Combine(spool.crcRanges[i].crc, next.crc, next.end-next.start)
+		spool.crcRanges[i].end = next.end
+
+		spool.crcRanges = slices.Delete(spool.crcRanges, i+1, i+2)
+	}
+}
+
+func (spool *Spool) Commit(_ context.Context, blobSize int64) error {
+	spool.mtx.Lock()
+	defer spool.mtx.Unlock()
+
+	if spool.committed {
+		return fmt.Errorf("failed to commit cache entry %q: already committed", spool.key)
+	}
+
+	// Make sure that the blob was written completely
+	var crc uint32
+
+	switch {
+	case blobSize == 0 && len(spool.crcRanges) == 0:
+		// Nothing was written, as expected
+	case len(spool.crcRanges) == 1 && spool.crcRanges[0].start == 0 && spool.crcRanges[0].end == blobSize:
+		crc = spool.crcRanges[0].crc
+	default:
+		return fmt.Errorf("failed to write %q file to the cache entry %q: expected %d bytes "+
+			"to be written contiguously", fileBlob, spool.key, blobSize)
+	}
+
+	spool.blobHeader.CRC32 = crc
+	spool.blobHeader.CompressedSize64 = uint64(blobSize)
+	spool.blobHeader.UncompressedSize64 = uint64(blobSize)
+	spool.blobHeader.CompressedSize = uint32(min(uint64(blobSize), math.MaxUint32))
+	spool.blobHeader.UncompressedSize = uint32(min(uint64(blobSize), math.MaxUint32))
+
+	// The blob is already written in place, so only let the ZIP writer
+	// account for it, with the underlying writer skipping over it
+	if err := spool.skipBlob(blobSize); err != nil {
+		return fmt.Errorf("failed to write %q file to the cache entry %q: %w",
+			fileBlob, spool.key, err)
+	}
+
+	if err := spool.zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finalize cache entry %q: %w", spool.key, err)
+	}
+
+	if err := spool.disk.accept(spool.key, spool.namespace, spool.file.Name(), spool.reservedBytes); err != nil {
+		return fmt.Errorf("failed to accept cache entry %q: %w", spool.key, err)
+	}
+
+	spool.committed = true
+
+	spool.disk.unreserve(spool.namespace, spool.reservedBytes)
+	spool.reservedBytes = 0
+
+	return nil
+}
+
+func (spool *Spool) skipBlob(blobSize int64) error {
+	spool.fileWriter.skip = blobSize
+
+	buf := make([]byte, min(blobSize, 1024*1024))
+
+	for remaining := blobSize; remaining > 0; {
+		n, err := spool.blobWriter.Write(buf[:min(remaining, int64(len(buf)))])
+		if err != nil {
+			return err
+		}
+
+		remaining -= int64(n)
+	}
+
+	return nil
+}
+
+// Close releases the spool, removing the cache entry's
+// temporary file if the spool was not committed.
+func (spool *Spool) Close() error {
+	spool.mtx.Lock()
+	defer spool.mtx.Unlock()
+
+	closeErr := spool.file.Close()
+
+	if !spool.committed {
+		if err := os.Remove(spool.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if spool.reservedBytes != 0 {
+		spool.disk.unreserve(spool.namespace, spool.reservedBytes)
+		spool.reservedBytes = 0
+	}
+
+	return closeErr
+}
+
+// spoolFileWriter writes the cache entry's ZIP file sequentially,
+// skipping over the blob's contents, which are already written in place.
+type spoolFileWriter struct {
+	file   *os.File
+	offset int64
+	skip   int64
+}
+
+func (writer *spoolFileWriter) Write(p []byte) (int, error) {
+	skipped := min(int64(len(p)), writer.skip)
+	writer.skip -= skipped
+	writer.offset += skipped
+
+	n, err := writer.file.WriteAt(p[skipped:], writer.offset)
+	writer.offset += int64(n)
+
+	return int(skipped) + n, err
+}
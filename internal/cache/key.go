@@ -0,0 +1,47 @@
+package cache
+
+import "strings"
+
+// variantKeySeparator separates the primary and the secondary keys
+// in the variant key, it can't appear in the URL-based primary keys.
+const variantKeySeparator = "\x00"
+
+// VariantKey returns a key for the cache entry that is one of the variants
+// of the cache entry stored under the primary key, see RFC 9111 "HTTP Caching",
+// §4.1 "Calculating Cache Keys with the Vary Header Field"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-4.1
+func VariantKey(primaryKey string, secondaryKey string) string {
+	return primaryKey + variantKeySeparator + secondaryKey
+}
+
+// SplitKey splits the key into the primary and the secondary keys,
+// the latter is empty when the key is not a variant key.
+func SplitKey(key string) (string, string) {
+	primaryKey, secondaryKey, _ := strings.Cut(key, variantKeySeparator)
+
+	return primaryKey, secondaryKey
+}
+
+// namespaceSeparator surrounds the namespace in the namespaced key,
+// it can't appear in the URL-based keys either.
+const namespaceSeparator = "\x01"
+
+// NamespacedKey returns a key for the cache entry that doesn't originate from a proxied
+// URL (e.g. Bazel's remote cache entries), which never collides with the URL-based keys.
+func NamespacedKey(namespace string, key string) string {
+	return namespaceSeparator + namespace + namespaceSeparator + key
+}
+
+// KeyNamespace returns the namespace of the namespaced key,
+// or an empty string when the key is not namespaced.
+func KeyNamespace(key string) string {
+	rest, ok := strings.CutPrefix(key, namespaceSeparator)
+	if !ok {
+		return ""
+	}
+
+	namespace, _, _ := strings.Cut(rest, namespaceSeparator)
+
+	return namespace
+}
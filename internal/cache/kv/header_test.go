@@ -5,8 +5,10 @@ import (
 	"github.com/cirruslabs/chacha/internal/cache/kv"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"reflect"
 	"testing"
 	"testing/quick"
+	"time"
 )
 
 func TestGetKeyMissing(t *testing.T) {
@@ -44,7 +46,29 @@ func TestSetGetKey(t *testing.T) {
 }
 
 func TestSetGetMetadata(t *testing.T) {
-	require.NoError(t, quick.Check(func(expectedMetadata cache.Metadata) bool {
+	// testing/quick is unable to generate time.Time values,
+	// so we construct the metadata from the generated primitives
+	require.NoError(t, quick.Check(func(
+		eTag string,
+		lastModified string,
+		statusCode int,
+		fetchedAt uint32,
+		freshnessLifetime int64,
+		staleIfError int64,
+		contentType string,
+	) bool {
+		expectedMetadata := cache.Metadata{
+			ETag:              eTag,
+			LastModified:      lastModified,
+			StatusCode:        statusCode,
+			FetchedAt:         time.Unix(int64(fetchedAt), 0).UTC(),
+			FreshnessLifetime: time.Duration(freshnessLifetime),
+			StaleIfError:      time.Duration(staleIfError),
+			Header: http.Header{
+				"Content-Type": []string{contentType},
+			},
+		}
+
 		header := http.Header{}
 
 		require.NoError(t, kv.SetMetadata(header, expectedMetadata))
@@ -52,7 +76,7 @@ func TestSetGetMetadata(t *testing.T) {
 		actualMetadata, err := kv.GetMetadata(header)
 		require.NoError(t, err)
 
-		return expectedMetadata == actualMetadata
+		return reflect.DeepEqual(expectedMetadata, actualMetadata)
 	}, &quick.Config{
 		MaxCount: 100_000,
 	}))
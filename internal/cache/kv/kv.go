@@ -40,12 +40,38 @@ func (kv *KV) Node() string {
 func (kv *KV) Get(
 	ctx context.Context,
 	key string,
-) (io.ReadCloser, cachepkg.Metadata, error) {
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, kv.url(), nil)
+) (io.ReadSeekCloser, cachepkg.Metadata, error) {
+	response, err := kv.get(ctx, key, 0)
 	if err != nil {
 		return nil, cachepkg.Metadata{}, err
 	}
 
+	// Retrieve output parameters
+	metadata, err := GetMetadata(response.Header)
+	if err != nil {
+		_ = response.Body.Close()
+
+		return nil, cachepkg.Metadata{}, err
+	}
+
+	return &Reader{
+		ctx:  ctx,
+		kv:   kv,
+		key:  key,
+		size: response.ContentLength,
+		body: response.Body,
+	}, metadata, nil
+}
+
+func (kv *KV) Head(
+	ctx context.Context,
+	key string,
+) (int64, cachepkg.Metadata, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, kv.url(), nil)
+	if err != nil {
+		return 0, cachepkg.Metadata{}, err
+	}
+
 	// Provide authorization
 	if kv.secret != "" {
 		request.SetBasicAuth("", kv.secret)
@@ -53,35 +79,38 @@ func (kv *KV) Get(
 
 	// Provide input parameters
 	if err := SetKey(request.Header, key); err != nil {
-		return nil, cachepkg.Metadata{}, err
+		return 0, cachepkg.Metadata{}, err
 	}
 
 	// Perform request
 	response, err := kv.httpClient.Do(request)
 	if err != nil {
-		return nil, cachepkg.Metadata{}, err
+		return 0, cachepkg.Metadata{}, err
 	}
+	defer response.Body.Close()
 
 	switch response.StatusCode {
 	case http.StatusOK:
 		// All good, continue
 	case http.StatusNotFound:
 		// Cache entry does not exist
-		return nil, cachepkg.Metadata{}, cachepkg.ErrNotFound
+		return 0, cachepkg.Metadata{}, cachepkg.ErrNotFound
 	default:
 		// Unexpected status code
-		return nil, cachepkg.Metadata{}, fmt.Errorf("unexpected HTTP %d", response.StatusCode)
+		return 0, cachepkg.Metadata{}, fmt.Errorf("unexpected HTTP %d", response.StatusCode)
 	}
 
 	// Retrieve output parameters
 	metadata, err := GetMetadata(response.Header)
 	if err != nil {
-		_ = response.Body.Close()
+		return 0, cachepkg.Metadata{}, err
+	}
 
-		return nil, cachepkg.Metadata{}, err
+	if response.ContentLength < 0 {
+		return 0, cachepkg.Metadata{}, fmt.Errorf("no cache entry size was provided by the node")
 	}
 
-	return response.Body, metadata, nil
+	return response.ContentLength, metadata, nil
 }
 
 func (kv *KV) Put(
@@ -89,12 +118,18 @@ func (kv *KV) Put(
 	key string,
 	metadata cachepkg.Metadata,
 	blobReader io.Reader,
+	blobSize int64,
 ) error {
 	request, err := http.NewRequestWithContext(ctx, http.MethodPut, kv.url(), blobReader)
 	if err != nil {
 		return err
 	}
 
+	// Let the node reserve the space for the cache entry up front
+	if blobSize >= 0 {
+		request.ContentLength = blobSize
+	}
+
 	// Provide authorization
 	if kv.secret != "" {
 		request.SetBasicAuth("", kv.secret)
@@ -117,11 +152,60 @@ func (kv *KV) Put(
 	defer response.Body.Close()
 
 	// Handle unexpected status code
-	if response.StatusCode != http.StatusOK {
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusRequestEntityTooLarge:
+		return cachepkg.ErrTooLarge
+	default:
 		return fmt.Errorf("unexpected HTTP %d", response.StatusCode)
 	}
+}
+
+func (kv *KV) get(ctx context.Context, key string, offset int64) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, kv.url(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Provide authorization
+	if kv.secret != "" {
+		request.SetBasicAuth("", kv.secret)
+	}
+
+	// Provide input parameters
+	if err := SetKey(request.Header, key); err != nil {
+		return nil, err
+	}
+
+	if offset != 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Perform request
+	response, err := kv.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusOK && offset == 0:
+		// All good, continue
+	case response.StatusCode == http.StatusPartialContent && offset != 0:
+		// All good, continue
+	case response.StatusCode == http.StatusNotFound:
+		_ = response.Body.Close()
+
+		// Cache entry does not exist
+		return nil, cachepkg.ErrNotFound
+	default:
+		_ = response.Body.Close()
+
+		// Unexpected status code
+		return nil, fmt.Errorf("unexpected HTTP %d", response.StatusCode)
+	}
 
-	return nil
+	return response, nil
 }
 
 func (kv *KV) url() string {
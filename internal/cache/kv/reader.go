@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader reads the cache entry from a remote node and supports seeking
+// by lazily re-requesting the cache entry starting from the new offset.
+type Reader struct {
+	ctx  context.Context
+	kv   *KV
+	key  string
+	size int64
+
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (reader *Reader) Read(p []byte) (int, error) {
+	if reader.size >= 0 && reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+
+	// Re-request the cache entry if we've seeked somewhere else
+	if reader.body == nil || reader.bodyOffset != reader.offset {
+		if reader.body != nil {
+			_ = reader.body.Close()
+			reader.body = nil
+		}
+
+		response, err := reader.kv.get(reader.ctx, reader.key, reader.offset)
+		if err != nil {
+			return 0, err
+		}
+
+		reader.body = response.Body
+		reader.bodyOffset = reader.offset
+	}
+
+	n, err := reader.body.Read(p)
+	reader.offset += int64(n)
+	reader.bodyOffset += int64(n)
+
+	return n, err
+}
+
+func (reader *Reader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = reader.offset + offset
+	case io.SeekEnd:
+		if reader.size < 0 {
+			return 0, errors.New("seek relative to the end is not supported: cache entry size is unknown")
+		}
+
+		newOffset = reader.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if newOffset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	reader.offset = newOffset
+
+	return newOffset, nil
+}
+
+func (reader *Reader) Close() error {
+	if reader.body == nil {
+		return nil
+	}
+
+	return reader.body.Close()
+}
@@ -12,11 +12,15 @@ func New() *NoOp {
 	return &NoOp{}
 }
 
-func (noop *NoOp) Get(_ context.Context, _ string) (io.ReadCloser, cachepkg.Metadata, error) {
+func (noop *NoOp) Get(_ context.Context, _ string) (io.ReadSeekCloser, cachepkg.Metadata, error) {
 	return nil, cachepkg.Metadata{}, cachepkg.ErrNotFound
 }
 
-func (noop *NoOp) Put(_ context.Context, _ string, _ cachepkg.Metadata, blobReader io.Reader) error {
+func (noop *NoOp) Head(_ context.Context, _ string) (int64, cachepkg.Metadata, error) {
+	return 0, cachepkg.Metadata{}, cachepkg.ErrNotFound
+}
+
+func (noop *NoOp) Put(_ context.Context, _ string, _ cachepkg.Metadata, blobReader io.Reader, _ int64) error {
 	_, err := io.Copy(io.Discard, blobReader)
 
 	return err
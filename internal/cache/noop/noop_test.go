@@ -21,7 +21,7 @@ func TestGet(t *testing.T) {
 	require.ErrorIs(t, err, cachepkg.ErrNotFound)
 
 	// ...even after a Put()
-	err = noop.Put(ctx, key, cachepkg.Metadata{ETag: uuid.NewString()}, bytes.NewReader([]byte("Hello, World!")))
+	err = noop.Put(ctx, key, cachepkg.Metadata{ETag: uuid.NewString()}, bytes.NewReader([]byte("Hello, World!")), -1)
 	require.NoError(t, err)
 
 	_, _, err = noop.Get(ctx, key)
@@ -38,7 +38,7 @@ func TestPut(t *testing.T) {
 	buf := bytes.NewBufferString("Hello, World!")
 	require.NotEmpty(t, buf.String())
 
-	err := noop.Put(ctx, key, cachepkg.Metadata{ETag: uuid.NewString()}, buf)
+	err := noop.Put(ctx, key, cachepkg.Metadata{ETag: uuid.NewString()}, buf, -1)
 	require.NoError(t, err)
 
 	require.Empty(t, buf.String())
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NewSpool creates a spool for the cache entry, which is either the cache's own spool
+// or, when the cache can't spool in place (e.g. a remote cluster node), a temporary
+// file that is put into the cache once committed.
+func NewSpool(ctx context.Context, cache Cache, key string, metadata Metadata, blobSize int64) (Spool, error) {
+	if spooler, ok := cache.(Spooler); ok {
+		return spooler.Spool(ctx, key, metadata, blobSize)
+	}
+
+	file, err := os.CreateTemp("", "chacha-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary file for the cache entry %q: %w", key, err)
+	}
+
+	return &fileSpool{
+		cache:    cache,
+		key:      key,
+		metadata: metadata,
+		file:     file,
+	}, nil
+}
+
+type fileSpool struct {
+	cache    Cache
+	key      string
+	metadata Metadata
+	file     *os.File
+}
+
+func (spool *fileSpool) ReadAt(p []byte, off int64) (int, error) {
+	return spool.file.ReadAt(p, off)
+}
+
+func (spool *fileSpool) WriteAt(p []byte, off int64) (int, error) {
+	return spool.file.WriteAt(p, off)
+}
+
+func (spool *fileSpool) Commit(ctx context.Context, blobSize int64) error {
+	return spool.cache.Put(ctx, spool.key, spool.metadata, io.NewSectionReader(spool.file, 0, blobSize), blobSize)
+}
+
+func (spool *fileSpool) Close() error {
+	closeErr := spool.file.Close()
+
+	if err := os.Remove(spool.file.Name()); err != nil {
+		return err
+	}
+
+	return closeErr
+}
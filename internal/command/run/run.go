@@ -7,8 +7,10 @@ import (
 	configpkg "github.com/cirruslabs/chacha/internal/config"
 	serverpkg "github.com/cirruslabs/chacha/internal/server"
 	"github.com/cirruslabs/chacha/internal/server/cluster"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
 	"github.com/cirruslabs/chacha/internal/server/rule"
 	"github.com/cirruslabs/chacha/internal/server/tlsinterceptor"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
 	"github.com/cirruslabs/chacha/pkg/localnetworkhelper"
 	"github.com/cirruslabs/chacha/pkg/privdrop"
 	"github.com/dustin/go-humanize"
@@ -76,7 +78,25 @@ func run(cmd *cobra.Command, _ []string) error {
 			return fmt.Errorf("failed to parse disk limit value %q: %w", config.Disk.Limit, err)
 		}
 
-		disk, err := diskpkg.New(config.Disk.Dir, limitBytes)
+		var diskOpts []diskpkg.Option
+
+		for namespace, namespaceLimit := range config.Disk.NamespaceLimits {
+			if !serverpkg.IsNamespace(namespace) {
+				return fmt.Errorf("failed to set disk limit of the namespace %q: unknown namespace, "+
+					"expected %q, %q or %q", namespace, serverpkg.NamespaceBazelAC, serverpkg.NamespaceBazelCAS,
+					serverpkg.NamespaceGradle)
+			}
+
+			namespaceLimitBytes, err := humanize.ParseBytes(namespaceLimit)
+			if err != nil {
+				return fmt.Errorf("failed to parse disk limit value %q of the namespace %q: %w",
+					namespaceLimit, namespace, err)
+			}
+
+			diskOpts = append(diskOpts, diskpkg.WithNamespaceLimit(namespace, namespaceLimitBytes))
+		}
+
+		disk, err := diskpkg.New(config.Disk.Dir, limitBytes, diskOpts...)
 		if err != nil {
 			return err
 		}
@@ -97,8 +117,65 @@ func run(cmd *cobra.Command, _ []string) error {
 		var rules rule.Rules
 
 		for _, configMatch := range config.Rules {
+			var ruleOpts []rule.Option
+
+			if configMatch.FreshFor != 0 {
+				ruleOpts = append(ruleOpts, rule.WithFreshFor(configMatch.FreshFor))
+			}
+
+			if configMatch.StaleIfError != 0 {
+				ruleOpts = append(ruleOpts, rule.WithStaleIfError(configMatch.StaleIfError))
+			}
+
+			if configMatch.BackgroundFill != nil {
+				ruleOpts = append(ruleOpts, rule.WithBackgroundFill(*configMatch.BackgroundFill))
+			}
+
+			if configMatch.MaxSize != "" {
+				maxSizeBytes, err := humanize.ParseBytes(configMatch.MaxSize)
+				if err != nil {
+					return fmt.Errorf("failed to parse max size value %q of the rule %q: %w",
+						configMatch.MaxSize, configMatch.Pattern, err)
+				}
+
+				ruleOpts = append(ruleOpts, rule.WithMaxSize(maxSizeBytes))
+			}
+
+			if configMatch.VerifyDigest != "" {
+				ruleOpts = append(ruleOpts, rule.WithVerifyDigest(configMatch.VerifyDigest))
+			}
+
+			if configMatch.CacheKey != "" {
+				ruleOpts = append(ruleOpts, rule.WithCacheKey(configMatch.CacheKey))
+			}
+
+			if configMatch.FollowRedirects {
+				ruleOpts = append(ruleOpts, rule.WithFollowRedirects(true))
+			}
+
+			if configMatch.NegativeTTL != 0 {
+				ruleOpts = append(ruleOpts, rule.WithNegativeTTL(configMatch.NegativeTTL))
+			}
+
+			if configMatch.ResumeAttempts != 0 {
+				ruleOpts = append(ruleOpts, rule.WithResumeAttempts(configMatch.ResumeAttempts))
+			}
+
+			if configMatch.ParallelFetch != 0 {
+				ruleOpts = append(ruleOpts, rule.WithParallelFetch(configMatch.ParallelFetch))
+			}
+
+			if configMatch.Upstream != nil {
+				ruleOpts = append(ruleOpts, rule.WithUpstream(upstreamSettings(configMatch.Upstream)))
+			}
+
+			if len(configMatch.Mirrors) != 0 {
+				ruleOpts = append(ruleOpts, rule.WithMirrors(configMatch.Mirrors))
+			}
+
 			rule, err := rule.New(configMatch.Pattern, configMatch.IgnoreAuthorizationHeader,
-				configMatch.IgnoreParameters, configMatch.DirectConnect, configMatch.DirectConnectHeader)
+				configMatch.IgnoreParameters, configMatch.DirectConnect, configMatch.DirectConnectHeader,
+				ruleOpts...)
 			if err != nil {
 				return err
 			}
@@ -109,6 +186,56 @@ func run(cmd *cobra.Command, _ []string) error {
 		opts = append(opts, serverpkg.WithRules(rules))
 	}
 
+	if config.BackgroundFill != nil {
+		var maxSizeBytes uint64
+
+		if config.BackgroundFill.MaxSize != "" {
+			maxSizeBytes, err = humanize.ParseBytes(config.BackgroundFill.MaxSize)
+			if err != nil {
+				return fmt.Errorf("failed to parse background fill max size value %q: %w",
+					config.BackgroundFill.MaxSize, err)
+			}
+		}
+
+		opts = append(opts, serverpkg.WithBackgroundFill(maxSizeBytes, config.BackgroundFill.Timeout))
+	}
+
+	if config.Upstream != nil {
+		opts = append(opts, serverpkg.WithUpstream(upstreamSettings(config.Upstream)))
+	}
+
+	if len(config.RegistryMirrors) != 0 {
+		var registryMirrors []*registrymirror.RegistryMirror
+
+		for _, configRegistryMirror := range config.RegistryMirrors {
+			var registryMirrorOpts []registrymirror.Option
+
+			if configRegistryMirror.Username != "" {
+				registryMirrorOpts = append(registryMirrorOpts, registrymirror.WithCredentials(
+					configRegistryMirror.Username, configRegistryMirror.Password))
+			}
+
+			registryMirror, err := registrymirror.New(configRegistryMirror.Addr, configRegistryMirror.Upstream,
+				registryMirrorOpts...)
+			if err != nil {
+				return err
+			}
+
+			registryMirrors = append(registryMirrors, registryMirror)
+		}
+
+		opts = append(opts, serverpkg.WithRegistryMirrors(registryMirrors...))
+	}
+
+	if config.Bazel != nil {
+		opts = append(opts, serverpkg.WithBazel(config.Bazel.Addr, config.Bazel.Token))
+	}
+
+	if config.Gradle != nil {
+		opts = append(opts, serverpkg.WithGradle(config.Gradle.Addr, config.Gradle.Username,
+			config.Gradle.Password))
+	}
+
 	if config.Cluster != nil {
 		opts = append(opts, serverpkg.WithCluster(cluster.New(config.Cluster.Secret,
 			config.Addr, config.Cluster.Nodes)))
@@ -121,3 +248,15 @@ func run(cmd *cobra.Command, _ []string) error {
 
 	return server.Run(cmd.Context())
 }
+
+func upstreamSettings(config *configpkg.Upstream) upstream.Settings {
+	return upstream.Settings{
+		DialTimeout:           config.DialTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		IdleReadTimeout:       config.IdleReadTimeout,
+		Retries:               config.Retries,
+		Proxy:                 config.Proxy,
+		NoProxy:               config.NoProxy,
+	}
+}
@@ -3,19 +3,26 @@ package config
 import (
 	"gopkg.in/yaml.v3"
 	"io"
+	"time"
 )
 
 type Config struct {
-	Addr           string          `yaml:"addr"`
-	Disk           *Disk           `yaml:"disk"`
-	TLSInterceptor *TLSInterceptor `yaml:"tls-interceptor"`
-	Rules          []Rule          `yaml:"rules"`
-	Cluster        *Cluster        `yaml:"cluster"`
+	Addr            string           `yaml:"addr"`
+	Disk            *Disk            `yaml:"disk"`
+	TLSInterceptor  *TLSInterceptor  `yaml:"tls-interceptor"`
+	Rules           []Rule           `yaml:"rules"`
+	Cluster         *Cluster         `yaml:"cluster"`
+	BackgroundFill  *BackgroundFill  `yaml:"background-fill"`
+	Upstream        *Upstream        `yaml:"upstream"`
+	RegistryMirrors []RegistryMirror `yaml:"registry-mirrors"`
+	Bazel           *Bazel           `yaml:"bazel"`
+	Gradle          *Gradle          `yaml:"gradle"`
 }
 
 type Disk struct {
-	Dir   string `yaml:"dir"`
-	Limit string `yaml:"limit"`
+	Dir             string            `yaml:"dir"`
+	Limit           string            `yaml:"limit"`
+	NamespaceLimits map[string]string `yaml:"namespace-limits"`
 }
 
 type TLSInterceptor struct {
@@ -24,11 +31,56 @@ type TLSInterceptor struct {
 }
 
 type Rule struct {
-	Pattern                   string   `yaml:"pattern"`
-	IgnoreAuthorizationHeader bool     `yaml:"ignore-authorization-header"`
-	IgnoreParameters          []string `yaml:"ignore-parameters"`
-	DirectConnect             bool     `yaml:"direct-connect"`
-	DirectConnectHeader       bool     `yaml:"direct-connect-header"`
+	Pattern                   string        `yaml:"pattern"`
+	IgnoreAuthorizationHeader bool          `yaml:"ignore-authorization-header"`
+	IgnoreParameters          []string      `yaml:"ignore-parameters"`
+	DirectConnect             bool          `yaml:"direct-connect"`
+	DirectConnectHeader       bool          `yaml:"direct-connect-header"`
+	FreshFor                  time.Duration `yaml:"fresh-for"`
+	StaleIfError              time.Duration `yaml:"stale-if-error"`
+	BackgroundFill            *bool         `yaml:"background-fill"`
+	MaxSize                   string        `yaml:"max-size"`
+	VerifyDigest              string        `yaml:"verify-digest"`
+	CacheKey                  string        `yaml:"cache-key"`
+	FollowRedirects           bool          `yaml:"follow-redirects"`
+	NegativeTTL               time.Duration `yaml:"negative-ttl"`
+	ResumeAttempts            uint          `yaml:"resume-attempts"`
+	ParallelFetch             uint          `yaml:"parallel-fetch"`
+	Upstream                  *Upstream     `yaml:"upstream"`
+	Mirrors                   []string      `yaml:"mirrors"`
+}
+
+type BackgroundFill struct {
+	MaxSize string        `yaml:"max-size"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type Upstream struct {
+	DialTimeout           time.Duration `yaml:"dial-timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls-handshake-timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response-header-timeout"`
+	IdleReadTimeout       time.Duration `yaml:"idle-read-timeout"`
+	Retries               uint          `yaml:"retries"`
+	Proxy                 string        `yaml:"proxy"`
+	NoProxy               string        `yaml:"no-proxy"`
+}
+
+type RegistryMirror struct {
+	Addr     string `yaml:"addr"`
+	Upstream string `yaml:"upstream"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Bazel struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+type Gradle struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type Cluster struct {
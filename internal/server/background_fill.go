@@ -0,0 +1,71 @@
+package server
+
+import (
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"net/http"
+	"time"
+)
+
+// Budget of the background fills when it's not configured explicitly (e.g. when
+// the background fill is only enabled per rule), so that the abandoned downloads
+// never consume the upstream bandwidth and the disk space indefinitely.
+const (
+	defaultBackgroundFillMaxSizeBytes = 10 * 1000 * 1000 * 1000
+	defaultBackgroundFillTimeout      = time.Hour
+)
+
+type backgroundFill struct {
+	maxSizeBytes uint64
+	timeout      time.Duration
+}
+
+// backgroundFillEnabled determines whether the fills for the given rule should
+// continue in the background when the client goes away, with the rule's setting
+// taking precedence over the server-wide setting.
+func (server *Server) backgroundFillEnabled(rule *rulepkg.Rule) bool {
+	if rule == nil {
+		return false
+	}
+
+	if enabled, ok := rule.BackgroundFill(); ok {
+		return enabled
+	}
+
+	return server.backgroundFill != nil
+}
+
+// backgroundFillBudget returns the background fill budget, with the defaults
+// being used in place of the settings that were not configured.
+func (server *Server) backgroundFillBudget() backgroundFill {
+	budget := backgroundFill{
+		maxSizeBytes: defaultBackgroundFillMaxSizeBytes,
+		timeout:      defaultBackgroundFillTimeout,
+	}
+
+	if server.backgroundFill != nil {
+		if server.backgroundFill.maxSizeBytes != 0 {
+			budget.maxSizeBytes = server.backgroundFill.maxSizeBytes
+		}
+
+		if server.backgroundFill.timeout != 0 {
+			budget.timeout = server.backgroundFill.timeout
+		}
+	}
+
+	return budget
+}
+
+// canFillInBackground determines whether the upstream's response
+// fits the background fill budget.
+func (server *Server) canFillInBackground(rule *rulepkg.Rule, upstreamResponse *http.Response) bool {
+	if !server.backgroundFillEnabled(rule) {
+		return false
+	}
+
+	// Responses of unknown size can't be checked against the budget in advance
+	if upstreamResponse.ContentLength < 0 {
+		return false
+	}
+
+	return uint64(upstreamResponse.ContentLength) <= server.backgroundFillBudget().maxSizeBytes
+}
@@ -0,0 +1,73 @@
+// Package digest implements verification of the content digests
+// in the "<algorithm>:<hex>" form used by the OCI distribution spec.
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+var (
+	ErrMismatch    = errors.New("digest mismatch")
+	ErrUnsupported = errors.New("unsupported digest")
+)
+
+// Reader computes the digest of the contents read through it and fails
+// with ErrMismatch instead of io.EOF when it doesn't match the expected one.
+type Reader struct {
+	reader    io.Reader
+	hash      hash.Hash
+	algorithm string
+	expected  string
+}
+
+func NewReader(reader io.Reader, expected string) (*Reader, error) {
+	algorithm, encoded, ok := strings.Cut(expected, ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is not in the <algorithm>:<hex> form", ErrUnsupported, expected)
+	}
+
+	var hash hash.Hash
+
+	switch algorithm {
+	case "sha256":
+		hash = sha256.New()
+	case "sha512":
+		hash = sha512.New()
+	default:
+		return nil, fmt.Errorf("%w: %q uses an unsupported algorithm %q", ErrUnsupported, expected, algorithm)
+	}
+
+	if _, err := hex.DecodeString(encoded); err != nil || len(encoded) != hash.Size()*2 {
+		return nil, fmt.Errorf("%w: %q has an invalid encoded portion", ErrUnsupported, expected)
+	}
+
+	return &Reader{
+		reader:    reader,
+		hash:      hash,
+		algorithm: algorithm,
+		expected:  algorithm + ":" + strings.ToLower(encoded),
+	}, nil
+}
+
+func (reader *Reader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+
+	reader.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		actual := reader.algorithm + ":" + hex.EncodeToString(reader.hash.Sum(nil))
+
+		if actual != reader.expected {
+			return n, fmt.Errorf("%w: expected %s, got %s", ErrMismatch, reader.expected, actual)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,40 @@
+package digest_test
+
+import (
+	"github.com/cirruslabs/chacha/internal/server/digest"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+const helloWorldSHA256 = "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+
+func TestMatch(t *testing.T) {
+	reader, err := digest.NewReader(strings.NewReader("Hello, World!"), helloWorldSHA256)
+	require.NoError(t, err)
+
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "Hello, World!", string(contents))
+}
+
+func TestMismatch(t *testing.T) {
+	reader, err := digest.NewReader(strings.NewReader("Hello, World"), helloWorldSHA256)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(reader)
+	require.ErrorIs(t, err, digest.ErrMismatch)
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, expected := range []string{
+		"dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f",
+		"md5:65a8e27d8879283831b664bd8b7f0ad4",
+		"sha256:not-hex",
+		"sha256:dffd6021",
+	} {
+		_, err := digest.NewReader(strings.NewReader(""), expected)
+		require.ErrorIs(t, err, digest.ErrUnsupported, expected)
+	}
+}
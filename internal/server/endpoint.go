@@ -0,0 +1,47 @@
+package server
+
+import (
+	responderpkg "github.com/cirruslabs/chacha/internal/server/responder"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/metric/noop"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// routeFunc handles the request, returning the responder and the operation name.
+type routeFunc func(writer http.ResponseWriter, request *http.Request) (responderpkg.Responder, string)
+
+// endpoint is an additional listener that serves its own
+// protocol (e.g. a registry mirror) alongside the proxy.
+type endpoint struct {
+	description string
+	listener    net.Listener
+	httpServer  *http.Server
+}
+
+func (server *Server) newEndpoint(addr string, description string, route routeFunc) (*endpoint, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.serve(writer, request, route)
+	})
+
+	return &endpoint{
+		description: description,
+		listener:    listener,
+		httpServer: &http.Server{
+			Handler: otelhttp.NewHandler(handler, "http.request",
+				otelhttp.WithMeterProvider(noop.NewMeterProvider())),
+			ReadHeaderTimeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+func (endpoint *endpoint) Addr() string {
+	return strings.ReplaceAll(endpoint.listener.Addr().String(), "[::]", "127.0.0.1")
+}
@@ -0,0 +1,410 @@
+// Package fill implements a single-flight cache entry fill that allows the concurrent
+// requests for the same cache entry to stream its contents from the cache entry's spool
+// as they arrive, without waiting for the fill to finish.
+//
+// When the spool fails (e.g. the disk is full), the cache entry is abandoned and the rest
+// of the contents is passed through to the readers that are still there, one write at a time.
+package fill
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"github.com/cirruslabs/chacha/internal/cache"
+	"io"
+	"slices"
+	"sync"
+)
+
+var ErrUnknownSize = errors.New("fill size is not known yet")
+
+type Fill struct {
+	metadata cache.Metadata
+	size     int64
+	spool    cache.Spool
+	idleFunc func()
+
+	mtx       sync.Mutex
+	changed   chan struct{}
+	written   int64
+	spooled   int64
+	pending   []writtenRange
+	spoolErr  error
+	window    []byte
+	done      bool
+	err       error
+	refs      int
+	positions map[*Reader]int64
+}
+
+// writtenRange is the range of the contents that was written out of order
+// and is not yet visible to the readers because of the gaps preceding it.
+type writtenRange struct {
+	start int64
+	end   int64
+}
+
+// New creates a new fill for the cache entry with the given metadata and
+// the expected size, which can be -1 when the size is not known in advance.
+//
+// The fill takes over the cache entry's spool, which is closed once the fill
+// is released by everyone. The returned fill is already referenced by the caller,
+// who needs to call Finish once the upstream's response is fully written
+// and Release after that.
+func New(metadata cache.Metadata, size int64, spool cache.Spool, opts ...Option) *Fill {
+	fill := &Fill{
+		metadata: metadata,
+		size:     size,
+		spool:    spool,
+		changed:  make(chan struct{}),
+		refs:     1,
+
+		positions: map[*Reader]int64{},
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(fill)
+	}
+
+	return fill
+}
+
+func (fill *Fill) Metadata() cache.Metadata {
+	return fill.metadata
+}
+
+// Size returns the fill size, which is either provided upfront
+// or becomes known once the fill successfully finishes.
+func (fill *Fill) Size() (int64, error) {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	if fill.size >= 0 {
+		return fill.size, nil
+	}
+
+	if fill.done && fill.err == nil {
+		return fill.written, nil
+	}
+
+	return 0, ErrUnknownSize
+}
+
+// Write appends the upstream's response contents to the cache entry's
+// spool and wakes up the readers that are waiting for more data.
+func (fill *Fill) Write(p []byte) (int, error) {
+	fill.mtx.Lock()
+	offset := fill.written
+	fill.mtx.Unlock()
+
+	return fill.WriteAt(p, offset)
+}
+
+// WriteAt writes the upstream's response contents to the cache entry's spool at the given
+// offset (e.g. when fetching them using concurrent range requests), with the readers only
+// seeing the contents once everything preceding them is written too.
+//
+// Once the spool fails, the contents are passed through to the readers instead, which
+// is only possible in order, so the out of order writes fail with the spool's error.
+func (fill *Fill) WriteAt(p []byte, off int64) (int, error) {
+	fill.mtx.Lock()
+	spoolErr := fill.spoolErr
+	fill.mtx.Unlock()
+
+	if spoolErr != nil {
+		return fill.passThrough(p, off)
+	}
+
+	n, err := fill.spool.WriteAt(p, off)
+
+	fill.mtx.Lock()
+
+	// The spool has failed in the meantime, so these
+	// contents are of no use for the cache entry anymore
+	if fill.spoolErr != nil {
+		spoolErr := fill.spoolErr
+		fill.mtx.Unlock()
+
+		return 0, spoolErr
+	}
+
+	fill.markWritten(off, off+int64(n))
+	fill.spooled = fill.written
+
+	if err != nil {
+		// Forget about the contents written out of order,
+		// since only the contiguous contents can be read now
+		fill.spoolErr = err
+		fill.pending = nil
+	}
+
+	fill.broadcast()
+	fill.mtx.Unlock()
+
+	if err != nil {
+		m, err := fill.passThrough(p[n:], off+int64(n))
+
+		return n + m, err
+	}
+
+	return n, nil
+}
+
+// passThrough passes the contents through to the readers once the spool fails, waiting for all
+// the readers to read the previously passed through contents first, since only the latest
+// contents are kept around.
+func (fill *Fill) passThrough(p []byte, off int64) (int, error) {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	if off != fill.written {
+		return 0, fill.spoolErr
+	}
+
+	for !fill.windowRead() {
+		changed := fill.changed
+
+		fill.mtx.Unlock()
+		<-changed
+		fill.mtx.Lock()
+	}
+
+	// There's no one to pass the contents through to
+	if len(fill.positions) == 0 {
+		return 0, fill.spoolErr
+	}
+
+	fill.window = append(fill.window[:0], p...)
+	fill.written += int64(len(p))
+	fill.broadcast()
+
+	return len(p), nil
+}
+
+// SpoolErr returns the error the cache entry's spool has failed with, if any,
+// in which case the cache entry can't be committed.
+func (fill *Fill) SpoolErr() error {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	return fill.spoolErr
+}
+
+// Written returns the size of the contiguous contents written so far.
+func (fill *Fill) Written() int64 {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	return fill.written
+}
+
+// Finish marks the fill as complete, with a non-nil error
+// indicating that the readers won't get the full contents.
+func (fill *Fill) Finish(err error) {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	fill.done = true
+	fill.err = err
+	fill.broadcast()
+}
+
+// Wait blocks until the fill finishes, its spool fails or the context
+// is canceled and returns the fill's, the spool's or the context's error, if any.
+//
+// There's no point in waiting for the fill once its spool fails, since only
+// the readers that keep up with it will receive the rest of its contents.
+func (fill *Fill) Wait(ctx context.Context) error {
+	for {
+		fill.mtx.Lock()
+		done, err, spoolErr, changed := fill.done, fill.err, fill.spoolErr, fill.changed
+		fill.mtx.Unlock()
+
+		if done && err != nil {
+			return err
+		}
+
+		if spoolErr != nil {
+			return spoolErr
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release dereferences the fill, with the last
+// reference closing the cache entry's spool.
+func (fill *Fill) Release() error {
+	fill.mtx.Lock()
+	fill.refs--
+	refs := fill.refs
+	fill.mtx.Unlock()
+
+	if refs != 0 {
+		return nil
+	}
+
+	return fill.spool.Close()
+}
+
+// NewReader returns a reader for the fill contents, which references the fill
+// and blocks until the requested contents are written, the fill finishes or
+// the context is canceled. Returns nil when the fill can no longer be used,
+// including when its spool has failed and the contents are passed through.
+//
+// The reader leaves the fill as soon as its context is canceled,
+// without waiting for the reader to be closed.
+func (fill *Fill) NewReader(ctx context.Context) *Reader {
+	fill.mtx.Lock()
+	defer fill.mtx.Unlock()
+
+	if fill.refs == 0 || fill.spoolErr != nil {
+		return nil
+	}
+
+	fill.refs++
+
+	reader := &Reader{
+		ctx:  ctx,
+		fill: fill,
+	}
+	reader.leave = sync.OnceFunc(func() {
+		fill.leave(reader)
+	})
+	reader.stopLeaving = context.AfterFunc(ctx, reader.leave)
+
+	fill.positions[reader] = 0
+
+	return reader
+}
+
+// leave is called when the reader leaves the fill, calling the
+// idle function when the last reader leaves an unfinished fill.
+func (fill *Fill) leave(reader *Reader) {
+	fill.mtx.Lock()
+	delete(fill.positions, reader)
+	idle := len(fill.positions) == 0 && !fill.done
+	fill.broadcast()
+	fill.mtx.Unlock()
+
+	if idle && fill.idleFunc != nil {
+		fill.idleFunc()
+	}
+}
+
+func (fill *Fill) readAt(reader *Reader, p []byte, off int64) (int, error) {
+	for {
+		fill.mtx.Lock()
+
+		// Reading at the offset means that everything preceding it was already
+		// read, which allows the passed through contents to advance
+		if position, ok := fill.positions[reader]; ok && position != off {
+			fill.positions[reader] = off
+			fill.broadcast()
+		}
+
+		spooled, written, window := fill.spooled, fill.written, fill.window
+		windowStart := written - int64(len(window))
+
+		switch {
+		case off < spooled:
+			fill.mtx.Unlock()
+
+			return fill.spool.ReadAt(p[:min(int64(len(p)), spooled-off)], off)
+		case off < windowStart:
+			// The contents were already passed through without us
+			spoolErr := fill.spoolErr
+			fill.mtx.Unlock()
+
+			return 0, spoolErr
+		case off < written:
+			n := copy(p, window[off-windowStart:])
+			fill.mtx.Unlock()
+
+			return n, nil
+		}
+
+		done, err, changed := fill.done, fill.err, fill.changed
+		fill.mtx.Unlock()
+
+		if done {
+			if err != nil {
+				return 0, err
+			}
+
+			return 0, io.EOF
+		}
+
+		select {
+		case <-changed:
+		case <-reader.ctx.Done():
+			return 0, reader.ctx.Err()
+		}
+	}
+}
+
+// windowRead tells whether all the readers have read the passed through
+// contents, must be called with the mutex held.
+func (fill *Fill) windowRead() bool {
+	for _, position := range fill.positions {
+		if position < fill.written {
+			return false
+		}
+	}
+
+	return true
+}
+
+// markWritten advances the contiguous written contents or remembers the range
+// written out of order until the gap preceding it is filled, must be called
+// with the mutex held.
+func (fill *Fill) markWritten(start int64, end int64) {
+	if start == end {
+		return
+	}
+
+	if start != fill.written {
+		i, _ := slices.BinarySearchFunc(fill.pending, start, func(pending writtenRange, start int64) int {
+			return cmp.Compare(pending.start, start)
+		})
+
+		// Extend the preceding range, if adjacent, or insert a new one
+		if i > 0 && fill.pending[i-1].end == start {
+			i--
+			fill.pending[i].end = end
+		} else {
+			fill.pending = slices.Insert(fill.pending, i, writtenRange{start: start, end: end})
+		}
+
+		// Merge with the following range, if adjacent now
+		if i+1 < len(fill.pending) && fill.pending[i+1].start == fill.pending[i].end {
+			fill.pending[i].end = fill.pending[i+1].end
+			fill.pending = slices.Delete(fill.pending, i+1, i+2)
+		}
+
+		return
+	}
+
+	fill.written = end
+
+	for len(fill.pending) != 0 && fill.pending[0].start == fill.written {
+		fill.written = fill.pending[0].end
+		fill.pending = fill.pending[1:]
+	}
+}
+
+// broadcast wakes up everyone waiting for the fill to change,
+// must be called with the mutex held.
+func (fill *Fill) broadcast() {
+	close(fill.changed)
+	fill.changed = make(chan struct{})
+}
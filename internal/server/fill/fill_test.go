@@ -0,0 +1,167 @@
+package fill_test
+
+import (
+	"context"
+	"errors"
+	"github.com/cirruslabs/chacha/internal/cache"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server/fill"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	inFlightFill := fill.New(cache.Metadata{ETag: `"v1"`}, -1, newSpool(t, -1))
+
+	reader := inFlightFill.NewReader(ctx)
+	require.NotNil(t, reader)
+
+	// The size is not known until the fill finishes
+	_, err := inFlightFill.Size()
+	require.ErrorIs(t, err, fill.ErrUnknownSize)
+
+	// Contents should be readable as soon as they're written
+	_, err = inFlightFill.Write([]byte("Hello, "))
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "Hello, ", string(buf[:n]))
+
+	// Readers should block until more contents are written
+	go func() {
+		_, _ = inFlightFill.Write([]byte("World!"))
+		inFlightFill.Finish(nil)
+	}()
+
+	restBytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "World!", string(restBytes))
+
+	size, err := inFlightFill.Size()
+	require.NoError(t, err)
+	require.EqualValues(t, 13, size)
+
+	// Readers should be able to seek
+	_, err = reader.Seek(-6, io.SeekEnd)
+	require.NoError(t, err)
+
+	restBytes, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "World!", string(restBytes))
+
+	// Once everyone releases the fill, it can no longer be used
+	require.NoError(t, reader.Close())
+	require.NoError(t, inFlightFill.Release())
+	require.Nil(t, inFlightFill.NewReader(ctx))
+}
+
+func TestFailed(t *testing.T) {
+	ctx := context.Background()
+
+	inFlightFill := fill.New(cache.Metadata{}, 13, newSpool(t, 13))
+
+	reader := inFlightFill.NewReader(ctx)
+	require.NotNil(t, reader)
+
+	_, err := inFlightFill.Write([]byte("Hello, "))
+	require.NoError(t, err)
+
+	expectedErr := errors.New("upstream has gone away")
+	inFlightFill.Finish(expectedErr)
+	require.ErrorIs(t, inFlightFill.Wait(ctx), expectedErr)
+
+	// Readers should receive the already written contents and then the error
+	readBytes, err := io.ReadAll(reader)
+	require.ErrorIs(t, err, expectedErr)
+	require.Equal(t, "Hello, ", string(readBytes))
+
+	require.NoError(t, reader.Close())
+	require.NoError(t, inFlightFill.Release())
+}
+
+func TestReaderCanceled(t *testing.T) {
+	var idleCalls int
+
+	inFlightFill := fill.New(cache.Metadata{}, -1, newSpool(t, -1), fill.WithIdleFunc(func() {
+		idleCalls++
+	}))
+
+	firstReader := inFlightFill.NewReader(context.Background())
+	require.NotNil(t, firstReader)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	secondReader := inFlightFill.NewReader(ctx)
+	require.NotNil(t, secondReader)
+
+	// Readers should stop waiting for the fill once their context is canceled
+	cancel()
+
+	_, err := secondReader.Read(make([]byte, 64))
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, inFlightFill.Wait(ctx), context.Canceled)
+
+	// Fill becomes idle once the last reader leaves before the fill finishes
+	require.NoError(t, secondReader.Close())
+	require.Equal(t, 0, idleCalls)
+
+	require.NoError(t, firstReader.Close())
+	require.Equal(t, 1, idleCalls)
+
+	inFlightFill.Finish(context.Canceled)
+	require.NoError(t, inFlightFill.Release())
+}
+
+func newSpool(t *testing.T, blobSize int64) cache.Spool {
+	disk, err := diskpkg.New(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+
+	spool, err := disk.Spool(context.Background(), "test", cache.Metadata{}, blobSize)
+	require.NoError(t, err)
+
+	return spool
+}
+
+func TestOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+
+	inFlightFill := fill.New(cache.Metadata{}, 13, newSpool(t, 13))
+
+	reader := inFlightFill.NewReader(ctx)
+	require.NotNil(t, reader)
+
+	// Contents written out of order should only be readable
+	// once everything preceding them is written too
+	_, err := inFlightFill.WriteAt([]byte("World!"), 7)
+	require.NoError(t, err)
+	_, err = inFlightFill.WriteAt([]byte("lo, "), 3)
+	require.NoError(t, err)
+
+	readCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	blockedReader := inFlightFill.NewReader(readCtx)
+	require.NotNil(t, blockedReader)
+
+	_, err = blockedReader.Read(make([]byte, 64))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, blockedReader.Close())
+
+	_, err = inFlightFill.WriteAt([]byte("Hel"), 0)
+	require.NoError(t, err)
+	inFlightFill.Finish(nil)
+
+	readBytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "Hello, World!", string(readBytes))
+
+	require.NoError(t, reader.Close())
+	require.NoError(t, inFlightFill.Release())
+}
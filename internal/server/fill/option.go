@@ -0,0 +1,12 @@
+package fill
+
+type Option func(fill *Fill)
+
+// WithIdleFunc sets the function that is called when the last reader leaves
+// the fill before it finishes (e.g. all the clients have gone away), which
+// allows to stop the fill early.
+func WithIdleFunc(idleFunc func()) Option {
+	return func(fill *Fill) {
+		fill.idleFunc = idleFunc
+	}
+}
@@ -0,0 +1,57 @@
+package fill
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+type Reader struct {
+	ctx         context.Context
+	fill        *Fill
+	offset      int64
+	leave       func()
+	stopLeaving func() bool
+}
+
+func (reader *Reader) Read(p []byte) (int, error) {
+	n, err := reader.fill.readAt(reader, p, reader.offset)
+	reader.offset += int64(n)
+
+	return n, err
+}
+
+func (reader *Reader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = reader.offset + offset
+	case io.SeekEnd:
+		size, err := reader.fill.Size()
+		if err != nil {
+			return 0, err
+		}
+
+		newOffset = size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if newOffset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	reader.offset = newOffset
+
+	return newOffset, nil
+}
+
+func (reader *Reader) Close() error {
+	reader.stopLeaving()
+	reader.leave()
+
+	return reader.fill.Release()
+}
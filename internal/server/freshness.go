@@ -0,0 +1,220 @@
+package server
+
+import (
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxDeltaSeconds is the greatest delta-seconds value we're obliged to handle
+// as per RFC 9111 "HTTP Caching", §1.2.2 "Delta Seconds"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-1.2.2
+const maxDeltaSeconds = 2147483648
+
+// isFresh determines whether the cache entry can be served
+// without contacting the upstream.
+func isFresh(metadata cachepkg.Metadata, rule *rulepkg.Rule) bool {
+	if metadata.FetchedAt.IsZero() {
+		return false
+	}
+
+	return time.Since(metadata.FetchedAt) < freshnessLifetime(metadata, rule)
+}
+
+// freshnessLifetime determines the cache entry's freshness lifetime, with the
+// rule's explicit freshness lifetime taking precedence over the upstream's one.
+func freshnessLifetime(metadata cachepkg.Metadata, rule *rulepkg.Rule) time.Duration {
+	// Negative cache entries are only fresh for the rule's negative TTL
+	if metadata.StatusCode != 0 {
+		if rule == nil {
+			return 0
+		}
+
+		return rule.NegativeTTL()
+	}
+
+	if rule != nil && rule.FreshFor() != 0 {
+		return rule.FreshFor()
+	}
+
+	if !upstreamLifetimesApply(rule) {
+		return 0
+	}
+
+	return metadata.FreshnessLifetime
+}
+
+// upstreamLifetimesApply determines whether the freshness lifetime and the stale-if-error
+// window specified by the upstream can be used. This is not the case for the cache entries
+// shared between different URLs using the rule's cache key template, because the upstream's
+// lifetimes were only meant for the URL the cache entry was fetched from, and serving such
+// cache entries without contacting the upstream would skip its authorization check.
+// For the same reason, the rule's freshness lifetime can't be set for such rules.
+func upstreamLifetimesApply(rule *rulepkg.Rule) bool {
+	return rule == nil || rule.CacheKey() == ""
+}
+
+// responseFreshnessLifetime calculates the upstream response's freshness lifetime
+// as per RFC 9111 "HTTP Caching", §4.2.1 "Calculating Freshness Lifetime"[1].
+//
+// Since Chacha is a shared cache, s-maxage takes precedence over max-age.
+// We don't use any heuristics, so responses without an explicit freshness
+// lifetime are always considered stale.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-4.2.1
+func responseFreshnessLifetime(header http.Header) time.Duration {
+	cacheControls := header.Values("Cache-Control")
+
+	// The no-cache response directive indicates that the response
+	// MUST NOT be used to satisfy any other request without
+	// forwarding it for validation and receiving a successful
+	// response
+	if headersContainDirective(cacheControls, "no-cache") {
+		return 0
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if _, ok := headersDirectiveValue(cacheControls, directive); !ok {
+			continue
+		}
+
+		// Invalid freshness information is treated as stale
+		lifetime, _ := directiveDuration(cacheControls, directive)
+
+		return lifetime
+	}
+
+	return 0
+}
+
+// canServeStaleOnError determines whether the stale cache entry can be served
+// when the upstream is not available, as per RFC 5861 "HTTP Cache-Control
+// Extensions for Stale Content", §4 "The stale-if-error Cache-Control Extension"[1].
+//
+// The rule's explicit stale-if-error window takes precedence over the upstream's one,
+// however, the client can always extend it using the stale-if-error or the max-stale
+// request directives, or forbid it using the no-cache request directive.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc5861#section-4
+func canServeStaleOnError(request *http.Request, metadata cachepkg.Metadata, rule *rulepkg.Rule) bool {
+	// Negative cache entries are never served past their negative TTL
+	if metadata.FetchedAt.IsZero() || metadata.StatusCode != 0 {
+		return false
+	}
+
+	if requestNoCache(request) {
+		return false
+	}
+
+	var window time.Duration
+
+	if upstreamLifetimesApply(rule) {
+		window = metadata.StaleIfError
+	}
+
+	if rule != nil && rule.StaleIfError() != 0 {
+		window = rule.StaleIfError()
+	}
+
+	if requestWindow, ok := directiveDuration(request.Header.Values("Cache-Control"), "stale-if-error"); ok {
+		window = max(window, requestWindow)
+	}
+
+	if maxStale, ok := requestMaxStale(request); ok {
+		window = max(window, maxStale)
+	}
+
+	staleness := time.Since(metadata.FetchedAt) - freshnessLifetime(metadata, rule)
+
+	return staleness <= window
+}
+
+// canServeStaleOffline determines whether the stale cache entry can be served without
+// contacting the upstream according to the client's max-stale request directive.
+func canServeStaleOffline(request *http.Request, metadata cachepkg.Metadata, rule *rulepkg.Rule) bool {
+	if metadata.FetchedAt.IsZero() || metadata.StatusCode != 0 {
+		return false
+	}
+
+	maxStale, ok := requestMaxStale(request)
+	if !ok {
+		return false
+	}
+
+	staleness := time.Since(metadata.FetchedAt) - freshnessLifetime(metadata, rule)
+
+	return staleness <= maxStale
+}
+
+// requestNoCache determines whether the client asks to not use the cache entry without
+// contacting the upstream, as per RFC 9111 "HTTP Caching", §5.2.1.4 "no-cache"[1].
+//
+// We go a bit further and re-fetch the cache entry from the upstream without revalidating
+// it, which allows the clients to replace the cache entries they don't trust.
+//
+// The Pragma header field is only considered when there's no Cache-Control header field
+// in the request, see RFC 9111 "HTTP Caching", §5.4 "Pragma"[2].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-5.2.1.4
+// [2]: https://datatracker.ietf.org/doc/html/rfc9111#section-5.4
+func requestNoCache(request *http.Request) bool {
+	if cacheControls := request.Header.Values("Cache-Control"); len(cacheControls) != 0 {
+		return headersContainDirective(cacheControls, "no-cache")
+	}
+
+	return headersContainDirective(request.Header.Values("Pragma"), "no-cache")
+}
+
+// requestOnlyIfCached determines whether the client asks to only use the cache entry, as per
+// RFC 9111 "HTTP Caching", §5.2.1.7 "only-if-cached"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-5.2.1.7
+func requestOnlyIfCached(request *http.Request) bool {
+	return headersContainDirective(request.Header.Values("Cache-Control"), "only-if-cached")
+}
+
+// requestMaxStale determines for how long the client is willing to accept a stale cache entry,
+// as per RFC 9111 "HTTP Caching", §5.2.1.2 "max-stale"[1], with the directive without a value
+// meaning that the client is willing to accept a stale cache entry of any age.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-5.2.1.2
+func requestMaxStale(request *http.Request) (time.Duration, bool) {
+	cacheControls := request.Header.Values("Cache-Control")
+
+	value, ok := headersDirectiveValue(cacheControls, "max-stale")
+	if !ok {
+		return 0, false
+	}
+
+	if value == "" {
+		return time.Duration(math.MaxInt64), true
+	}
+
+	return directiveDuration(cacheControls, "max-stale")
+}
+
+// responseStaleIfError determines the window during which the upstream allows
+// the response to be served stale when it's not available.
+func responseStaleIfError(header http.Header) time.Duration {
+	window, _ := directiveDuration(header.Values("Cache-Control"), "stale-if-error")
+
+	return window
+}
+
+func directiveDuration(cacheControls []string, directive string) (time.Duration, bool) {
+	value, ok := headersDirectiveValue(cacheControls, directive)
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(min(seconds, maxDeltaSeconds)) * time.Second, true
+}
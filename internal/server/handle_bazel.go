@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/subtle"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/digest"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Cache key namespaces of the Bazel's action cache (AC) and
+// content-addressable storage (CAS) entries, see cache.NamespacedKey.
+const (
+	NamespaceBazelAC  = "bazel-ac"
+	NamespaceBazelCAS = "bazel-cas"
+)
+
+// bazelPathRe matches the Bazel's HTTP remote cache paths[1], which
+// can be prefixed when the --remote_cache URL has a path component.
+//
+// [1]: https://bazel.build/remote/caching#http-caching
+var bazelPathRe = regexp.MustCompile(`^(?:/.*)?/(ac|cas)/([0-9a-f]{64})$`)
+
+type bazel struct {
+	addr  string
+	token string
+}
+
+func (server *Server) newBazelEndpoint() (*endpoint, error) {
+	return server.newEndpoint(server.bazel.addr, "Bazel remote cache", server.handleBazel)
+}
+
+// handleBazel serves the Bazel's HTTP remote cache protocol, storing the action cache (AC)
+// and the content-addressable storage (CAS) entries in their own cache key namespaces.
+func (server *Server) handleBazel(writer http.ResponseWriter, request *http.Request) (responder.Responder, string) {
+	matches := bazelPathRe.FindStringSubmatch(request.URL.Path)
+	if matches == nil {
+		return responder.NewCodef(http.StatusNotFound, "not an action cache or a CAS path"), "bazel-unknown"
+	}
+
+	kind, hash := matches[1], matches[2]
+
+	operation := "bazel-" + kind + "-" + strings.ToLower(request.Method)
+
+	// Perform authentication
+	if responder := server.performBazelAuth(request); responder != nil {
+		return responder, operation
+	}
+
+	namespace := NamespaceBazelAC
+	if kind == "cas" {
+		namespace = NamespaceBazelCAS
+	}
+
+	key := cachepkg.NamespacedKey(namespace, hash)
+
+	switch request.Method {
+	case http.MethodGet:
+		return server.handleBlobGet(writer, request, key), operation
+	case http.MethodHead:
+		return server.handleBlobHead(writer, request, key), operation
+	case http.MethodPut:
+		var body io.Reader = request.Body
+
+		// CAS entries are addressed by the SHA-256 of their contents,
+		// so make sure that the uploaded contents actually match it
+		if kind == "cas" {
+			digestReader, err := digest.NewReader(request.Body, "sha256:"+hash)
+			if err != nil {
+				return responder.NewCodef(http.StatusBadRequest, "failed to verify the CAS entry: %v", err),
+					operation
+			}
+
+			body = digestReader
+		}
+
+		return server.handleBlobPut(request, key, body), operation
+	default:
+		return responder.NewCodef(http.StatusMethodNotAllowed, "unsupported method %s", request.Method),
+			operation
+	}
+}
+
+// performBazelAuth checks the token, which Bazel can provide either as a password in the
+// --remote_cache URL (e.g. https://bazel:<token>@chacha.example.com) or as a bearer token
+// using --remote_header=Authorization="Bearer <token>".
+func (server *Server) performBazelAuth(request *http.Request) responder.Responder {
+	if server.bazel.token == "" {
+		return nil
+	}
+
+	_, providedToken, ok := request.BasicAuth()
+	if !ok {
+		providedToken, ok = strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	}
+
+	if !ok {
+		return responder.NewCodef(http.StatusUnauthorized, "failed to get basic auth or bearer token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(server.bazel.token), []byte(providedToken)) != 1 {
+		return responder.NewCodef(http.StatusUnauthorized, "invalid token")
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/digest"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleBlobGet serves the cache entry that is stored as is (e.g. Bazel's CAS entry).
+func (server *Server) handleBlobGet(
+	writer http.ResponseWriter,
+	request *http.Request,
+	key string,
+) responder.Responder {
+	cacheEntryReader, _, err := server.cache(key).Get(request.Context(), key)
+	if err != nil {
+		if errors.Is(err, cachepkg.ErrNotFound) {
+			return responder.NewCodef(http.StatusNotFound, "no cache entry found for key %q", key)
+		}
+
+		return responder.NewCodef(http.StatusInternalServerError, "failed to get cache entry for key %q: %v",
+			key, err)
+	}
+	defer cacheEntryReader.Close()
+
+	size, err := cacheEntryReader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to determine the size "+
+			"of the cache entry for key %q: %v", key, err)
+	}
+
+	if _, err := cacheEntryReader.Seek(0, io.SeekStart); err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to seek the cache entry "+
+			"for key %q: %v", key, err)
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	writer.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(writer, cacheEntryReader); err != nil {
+		return responder.NewEmptyf("failed to write all data to the client: %v", err)
+	}
+
+	return responder.NewEmptyf("cache entry read successfully")
+}
+
+// handleBlobHead answers whether the cache entry that is stored as is exists.
+func (server *Server) handleBlobHead(
+	writer http.ResponseWriter,
+	request *http.Request,
+	key string,
+) responder.Responder {
+	size, _, err := server.cache(key).Head(request.Context(), key)
+	if err != nil {
+		if errors.Is(err, cachepkg.ErrNotFound) {
+			return responder.NewCodef(http.StatusNotFound, "no cache entry found for key %q", key)
+		}
+
+		return responder.NewCodef(http.StatusInternalServerError, "failed to get cache entry for key %q: %v",
+			key, err)
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	return responder.NewCodef(http.StatusOK, "cache entry exists")
+}
+
+// handleBlobPut stores the request's body (or its verifying wrapper) as the cache entry.
+func (server *Server) handleBlobPut(request *http.Request, key string, body io.Reader) responder.Responder {
+	metadata := cachepkg.Metadata{
+		FetchedAt: time.Now().UTC(),
+	}
+
+	if err := server.cache(key).Put(request.Context(), key, metadata, body, request.ContentLength); err != nil {
+		switch {
+		case errors.Is(err, digest.ErrMismatch):
+			return responder.NewCodef(http.StatusBadRequest, "cache entry doesn't match its digest: %v", err)
+		case errors.Is(err, cachepkg.ErrTooLarge):
+			return responder.NewCodef(http.StatusRequestEntityTooLarge, "unable to put cache entry: %v", err)
+		default:
+			return responder.NewCodef(http.StatusInternalServerError, "unable to put cache entry: %v", err)
+		}
+	}
+
+	return responder.NewCodef(http.StatusOK, "cache entry written successfully")
+}
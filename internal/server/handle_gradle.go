@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/subtle"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// NamespaceGradle is the cache key namespace of the Gradle's
+// build cache entries, see cache.NamespacedKey.
+const NamespaceGradle = "gradle"
+
+// gradlePathRe matches the Gradle's HTTP build cache paths[1], which
+// can be prefixed when the build cache URL has a longer path component.
+//
+// [1]: https://docs.gradle.org/current/userguide/build_cache.html#sec:build_cache_configure_remote
+var gradlePathRe = regexp.MustCompile(`^(?:/.*)?/cache/([0-9a-f]{1,128})$`)
+
+type gradle struct {
+	addr     string
+	username string
+	password string
+}
+
+func (server *Server) newGradleEndpoint() (*endpoint, error) {
+	return server.newEndpoint(server.gradle.addr, "Gradle build cache", server.handleGradle)
+}
+
+// handleGradle serves the Gradle's HTTP build cache protocol, storing
+// the build cache entries in their own cache key namespace.
+func (server *Server) handleGradle(writer http.ResponseWriter, request *http.Request) (responder.Responder, string) {
+	matches := gradlePathRe.FindStringSubmatch(request.URL.Path)
+	if matches == nil {
+		return responder.NewCodef(http.StatusNotFound, "not a build cache path"), "gradle-unknown"
+	}
+
+	operation := "gradle-" + strings.ToLower(request.Method)
+
+	// Perform authentication
+	if responder := server.performGradleAuth(request); responder != nil {
+		return responder, operation
+	}
+
+	key := cachepkg.NamespacedKey(NamespaceGradle, matches[1])
+
+	switch request.Method {
+	case http.MethodGet:
+		return server.handleBlobGet(writer, request, key), operation
+	case http.MethodHead:
+		return server.handleBlobHead(writer, request, key), operation
+	case http.MethodPut:
+		return server.handleBlobPut(request, key, request.Body), operation
+	default:
+		return responder.NewCodef(http.StatusMethodNotAllowed, "unsupported method %s", request.Method),
+			operation
+	}
+}
+
+// performGradleAuth checks the basic auth credentials that Gradle provides
+// when they're specified in the remote build cache's configuration.
+func (server *Server) performGradleAuth(request *http.Request) responder.Responder {
+	if server.gradle.username == "" && server.gradle.password == "" {
+		return nil
+	}
+
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return responder.NewCodef(http.StatusUnauthorized, "failed to get basic auth")
+	}
+
+	usernameMatches := subtle.ConstantTimeCompare([]byte(server.gradle.username), []byte(username))
+	passwordMatches := subtle.ConstantTimeCompare([]byte(server.gradle.password), []byte(password))
+
+	if usernameMatches&passwordMatches != 1 {
+		return responder.NewCodef(http.StatusUnauthorized, "invalid credentials")
+	}
+
+	return nil
+}
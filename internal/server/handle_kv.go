@@ -6,8 +6,9 @@ import (
 	cachepkg "github.com/cirruslabs/chacha/internal/cache"
 	"github.com/cirruslabs/chacha/internal/cache/kv"
 	"github.com/cirruslabs/chacha/internal/server/responder"
-	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (server *Server) handleClusterGet(writer http.ResponseWriter, request *http.Request) responder.Responder {
@@ -39,10 +40,13 @@ func (server *Server) handleClusterGet(writer http.ResponseWriter, request *http
 			"to the requester: %v", err)
 	}
 
-	// Write cache entry to the requester
-	if _, err := io.Copy(writer, cacheEntryReader); err != nil {
-		return responder.NewCodef(http.StatusInternalServerError, "unable to write cache entry: %v", err)
-	}
+	// Write cache entry to the requester, http.ServeContent() takes care of
+	// the Range header, which is used by the KV's reader when seeking
+	//
+	// Explicitly unset the Content-Type to prevent http.ServeContent() from sniffing it.
+	writer.Header()["Content-Type"] = nil
+
+	http.ServeContent(writer, request, "", time.Time{}, cacheEntryReader)
 
 	if err := cacheEntryReader.Close(); err != nil {
 		return responder.NewCodef(http.StatusInternalServerError, "unable to close cache entry: %v", err)
@@ -51,6 +55,40 @@ func (server *Server) handleClusterGet(writer http.ResponseWriter, request *http
 	return responder.NewEmptyf("cache entry read successfully")
 }
 
+func (server *Server) handleClusterHead(writer http.ResponseWriter, request *http.Request) responder.Responder {
+	// Perform authentication
+	if responder := server.performClusterAuth(request); responder != nil {
+		return responder
+	}
+
+	// Retrieve input parameters
+	key, err := kv.GetKey(request.Header)
+	if err != nil {
+		return responder.NewCodef(http.StatusBadRequest, "failed to determine the key: %v", err)
+	}
+
+	// Read cache entry's size and metadata from the local disk
+	size, metadata, err := server.disk.Head(request.Context(), key)
+	if err != nil {
+		if errors.Is(err, cachepkg.ErrNotFound) {
+			return responder.NewCodef(http.StatusNotFound, "no cache entry found for key %s", key)
+		}
+
+		return responder.NewCodef(http.StatusInternalServerError, "failed to get cache entry for key %s: %v",
+			key, err)
+	}
+
+	// Expose cache entry size and metadata to the requester via HTTP headers
+	if err := kv.SetMetadata(writer.Header(), metadata); err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to provide the metadata "+
+			"to the requester: %v", err)
+	}
+
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	return responder.NewCodef(http.StatusOK, "cache entry metadata read successfully")
+}
+
 func (server *Server) handleClusterPut(_ http.ResponseWriter, request *http.Request) responder.Responder {
 	// Perform authentication
 	if responder := server.performClusterAuth(request); responder != nil {
@@ -69,7 +107,11 @@ func (server *Server) handleClusterPut(_ http.ResponseWriter, request *http.Requ
 	}
 
 	// Write cache entry to the local disk
-	if err := server.disk.Put(request.Context(), key, metadata, request.Body); err != nil {
+	if err := server.disk.Put(request.Context(), key, metadata, request.Body, request.ContentLength); err != nil {
+		if errors.Is(err, cachepkg.ErrTooLarge) {
+			return responder.NewCodef(http.StatusRequestEntityTooLarge, "unable to put cache entry: %v", err)
+		}
+
 		return responder.NewCodef(http.StatusInternalServerError, "unable to put cache entry: %v", err)
 	}
 
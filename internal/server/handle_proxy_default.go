@@ -1,22 +1,28 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	cachepkg "github.com/cirruslabs/chacha/internal/cache"
 	"github.com/cirruslabs/chacha/internal/cache/kv"
+	"github.com/cirruslabs/chacha/internal/server/fill"
 	"github.com/cirruslabs/chacha/internal/server/responder"
 	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
+	"github.com/puzpuzpuz/xsync/v4"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
-//nolint:cyclop,funlen // not sure if chopping this function will make the matters easier
 func (server *Server) handleProxyDefault(writer http.ResponseWriter, request *http.Request) responder.Responder {
 	// From http.Request's URL field documentation:
 	//
@@ -35,16 +41,53 @@ func (server *Server) handleProxyDefault(writer http.ResponseWriter, request *ht
 		return responder.NewCodef(http.StatusBadRequest, "Host header is empty")
 	}
 
+	return server.proxyRequest(writer, request)
+}
+
+// proxyRequest serves the request whose URL is fully-qualified (i.e. points to the upstream)
+// either from the cache or from the upstream, filling the cache entry when allowed.
+//
+//nolint:cyclop,funlen // not sure if chopping this function will make the matters easier
+func (server *Server) proxyRequest(writer http.ResponseWriter, request *http.Request) responder.Responder {
 	// Determine our caching policy for this request
 	rule := server.rules.Get(request.URL.String())
 
 	// Determine the cache key
 	key := server.cacheKey(request, rule)
 
-	// Prevent multiple in-flight proxy requests to the same key,
-	// otherwise we may needlessly fetch the content twice
+	// Prevent multiple in-flight proxy requests to the same key, otherwise we may needlessly
+	// fetch the content twice. The lock is only held until the upstream's response headers
+	// arrive, after which the concurrent requests can join the in-flight fill, if any.
 	server.kmutex.Lock(key)
-	defer server.kmutex.Unlock(key)
+	unlockKey := sync.OnceFunc(func() {
+		server.kmutex.Unlock(key)
+	})
+	defer unlockKey()
+
+	// Client's request directives, see RFC 9111 "HTTP Caching",
+	// §5.2.1 "Request Directives"[1]
+	//
+	// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-5.2.1
+	noCache := requestNoCache(request)
+	onlyIfCached := requestOnlyIfCached(request)
+
+	// Join the in-flight fill for this key, if any
+	//
+	// Joining the fill involves contacting the upstream,
+	// which is not what the only-if-cached client wants
+	if request.Method == http.MethodGet && !onlyIfCached {
+		if inFlightFill, ok := server.fills.Load(key); ok {
+			if fillReader := inFlightFill.NewReader(request.Context()); fillReader != nil {
+				unlockKey()
+
+				defer func() {
+					_ = fillReader.Close()
+				}()
+
+				return server.respondFromFill(writer, request, rule, key, inFlightFill, fillReader)
+			}
+		}
+	}
 
 	// Determine the cache implementation to use
 	//
@@ -53,29 +96,357 @@ func (server *Server) handleProxyDefault(writer http.ResponseWriter, request *ht
 
 	// Acquire a handle to the cache entry, if any, because we
 	// need the ETag first to provide it to the origin server
-	var cacheEntryReader io.ReadCloser
-	var metadata cachepkg.Metadata
-	var err error
+	cacheEntryReader, cacheEntrySize, metadata, err := openCacheEntry(request, cache, key)
+
+	// The upstream's response varies on some request header fields,
+	// so we need to pick a variant that matches our request
+	entryKey := key
+
+	if err == nil && len(metadata.Vary) != 0 {
+		if cacheEntryReader != nil {
+			_ = cacheEntryReader.Close()
+		}
+
+		entryKey = cachepkg.VariantKey(key, secondaryCacheKey(request, metadata.Vary))
+
+		cacheEntryReader, cacheEntrySize, metadata, err = openCacheEntry(request, cache, entryKey)
+	}
 
-	cacheEntryReader, metadata, err = cache.Get(request.Context(), key)
 	if err != nil && !errors.Is(err, cachepkg.ErrNotFound) {
 		if kv, ok := cache.(*kv.KV); ok {
 			return responder.NewCodef(http.StatusBadGateway, "failed to retrieve cache entry "+
-				"for key %q: cluster node %s is not available: %v", key, kv.Node(), err)
+				"for key %q: cluster node %s is not available: %v", entryKey, kv.Node(), err)
 		}
 
 		return responder.NewCodef(http.StatusInternalServerError, "failed to retrieve cache entry "+
-			"for key %q: %v", key, err)
+			"for key %q: %v", entryKey, err)
 	}
+
+	cacheEntryFound := err == nil
+
 	if cacheEntryReader != nil {
 		defer func() {
 			_ = cacheEntryReader.Close()
 		}()
 	}
 
+	// Serve the cache entry without contacting the upstream if it's still fresh
+	if cacheEntryFound && !noCache && isFresh(metadata, rule) {
+		unlockKey()
+
+		setCacheEntryHeaders(writer.Header(), metadata)
+
+		return server.respondWithCacheEntry(writer, request, rule, entryKey, cacheEntryReader, cacheEntrySize,
+			metadata, "fresh-hit")
+	}
+
+	// The client doesn't want us to contact the upstream, so either serve
+	// a stale cache entry, if the client allows it, or give up
+	if onlyIfCached {
+		unlockKey()
+
+		if cacheEntryFound && !noCache && canServeStaleOffline(request, metadata, rule) {
+			setCacheEntryHeaders(writer.Header(), metadata)
+
+			writer.Header().Set("Warning", `110 - "Response is Stale"`)
+			writer.Header().Set("X-Chacha-Stale", "1")
+
+			return server.respondWithCacheEntry(writer, request, rule, entryKey, cacheEntryReader,
+				cacheEntrySize, metadata, "stale-hit")
+		}
+
+		return responder.NewCodef(http.StatusGatewayTimeout, "no suitable cache entry found "+
+			"for key %q and the client asked to not contact the upstream", entryKey)
+	}
+
+	// The client asks to re-fetch the cache entry, so we don't revalidate it
+	// and don't use it for the upstream's 304 responses
+	revalidate := cacheEntryFound && !noCache
+
 	// Always perform an upstream request in order to guarantee that
 	// the requestor still has access to the upstream resource
 	//
+	// Fills need to outlive the client's request (e.g. when the concurrent requests join
+	// the fill or when the fill continues in the background), so the upstream request is
+	// performed on a server-owned context in this case, which is then either handed over
+	// to the fill or canceled once the client goes away or we're done with the request
+	upstreamCtx, cancelUpstream := request.Context(), context.CancelFunc(func() {})
+	stopCancelingUpstream := func() bool { return false }
+
+	if request.Method == http.MethodGet {
+		upstreamCtx, cancelUpstream = context.WithCancel(server.backgroundCtx)
+		stopCancelingUpstream = context.AfterFunc(request.Context(), cancelUpstream)
+	}
+
+	upstreamCtxHandedOver := false
+
+	defer func() {
+		if !upstreamCtxHandedOver {
+			cancelUpstream()
+		}
+	}()
+
+	upstreamRequest, err := server.newUpstreamRequest(upstreamCtx, request, rule, metadata, revalidate)
+	if err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to create an upstream request: %v",
+			err)
+	}
+
+	// Perform an upstream request
+	upstreamResponse, err := server.doUpstreamRequest(upstreamRequest, rule)
+	if err != nil {
+		// Serve the stale cache entry instead of failing, if allowed
+		if cacheEntryFound && canServeStaleOnError(request, metadata, rule) {
+			unlockKey()
+
+			server.logger.Warnf("serving stale cache entry for key %q, failed to perform "+
+				"a request to the upstream: %v", entryKey, err)
+
+			return server.respondWithStaleCacheEntry(writer, request, rule, entryKey, cacheEntryReader,
+				cacheEntrySize, metadata)
+		}
+
+		return responder.NewCodef(http.StatusInternalServerError, "failed to perform a request "+
+			"to the upstream: %v", err)
+	}
+
+	// Our cache entry is outdated and caching is allowed, refresh cache entry contents,
+	// with the fill taking over the upstream's response body and context
+	if upstreamResponse.StatusCode == http.StatusOK && server.shouldCache(request, upstreamResponse, rule) {
+		server.propagateUpstreamResponseHeaders(writer, upstreamResponse)
+
+		upstreamCtxHandedOver = true
+		stopCancelingUpstream()
+
+		// Resume the download if the upstream connection breaks
+		// in the middle, so that the fill doesn't have to fail
+		upstreamResponse.Body = server.newResumingBody(upstreamResponse, rule)
+
+		return server.fillCacheEntry(writer, request, rule, cache, key, upstreamResponse, cancelUpstream, unlockKey)
+	}
+
+	defer upstreamResponse.Body.Close()
+
+	// Serve the stale cache entry instead of the upstream's server error, if allowed
+	if upstreamResponse.StatusCode >= 500 && cacheEntryFound &&
+		canServeStaleOnError(request, metadata, rule) {
+		unlockKey()
+
+		server.logger.Warnf("serving stale cache entry for key %q, upstream responded "+
+			"with HTTP %d", entryKey, upstreamResponse.StatusCode)
+
+		return server.respondWithStaleCacheEntry(writer, request, rule, entryKey, cacheEntryReader,
+			cacheEntrySize, metadata)
+	}
+
+	server.propagateUpstreamResponseHeaders(writer, upstreamResponse)
+
+	// Remember that the resource doesn't exist for a while, if allowed
+	//
+	// Negative cache entries are always stored under the primary key,
+	// replacing the variants (if any), since their Vary is not honored
+	if isNegativeStatusCode(upstreamResponse.StatusCode) && rule != nil && rule.NegativeTTL() != 0 &&
+		server.shouldCache(request, upstreamResponse, rule) {
+		return server.respondWithNegativeCacheEntry(writer, request, cache, key, upstreamResponse)
+	}
+
+	switch {
+	case upstreamResponse.StatusCode == http.StatusNotModified && revalidate:
+		unlockKey()
+
+		// Our cached entry is up-to-date, however, the 304 response
+		// usually lacks most of the headers, so add the stored ones
+		updateCacheEntryHeaders(writer.Header(), metadata)
+
+		return server.respondWithCacheEntry(writer, request, rule, entryKey, cacheEntryReader, cacheEntrySize,
+			metadata, "hit")
+	default:
+		unlockKey()
+
+		// Caching is not allowed
+		return server.respondWithUpstreamResponse(writer, upstreamResponse)
+	}
+}
+
+// fillCacheEntry spools the upstream's response directly into the cache entry and streams
+// it to the client from there, also allowing the concurrent requests for the same key
+// to join this fill in-flight.
+//
+// The fill takes over the upstream's response body and the upstream's request context,
+// which are not tied to the client's request, so that the fill is not affected by the
+// client going away while the concurrent requests are still streaming it.
+func (server *Server) fillCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	rule *rulepkg.Rule,
+	cache cachepkg.Cache,
+	key string,
+	upstreamResponse *http.Response,
+	cancelUpstream context.CancelFunc,
+	unlockKey func(),
+) responder.Responder {
+	// Release the upstream's response body and context
+	// once we're done, unless they're handed over to the fill
+	handedOver := false
+
+	defer func() {
+		if !handedOver {
+			_ = upstreamResponse.Body.Close()
+			cancelUpstream()
+		}
+	}()
+
+	newMetadata := cachepkg.Metadata{
+		ETag:              upstreamResponse.Header.Get("ETag"),
+		LastModified:      upstreamResponse.Header.Get("Last-Modified"),
+		FetchedAt:         time.Now().UTC(),
+		FreshnessLifetime: responseFreshnessLifetime(upstreamResponse.Header),
+		StaleIfError:      responseStaleIfError(upstreamResponse.Header),
+		Header:            storableHeader(upstreamResponse.Header),
+	}
+
+	// The upstream's response varies on some request header fields,
+	// so store it as a variant and point the primary key to it
+	newEntryKey := key
+
+	vary, _ := responseVary(upstreamResponse.Header)
+	if len(vary) != 0 {
+		err := cache.Put(request.Context(), key, cachepkg.Metadata{
+			FetchedAt: newMetadata.FetchedAt,
+			Vary:      vary,
+		}, bytes.NewReader(nil), 0)
+		if err != nil {
+			return responder.NewCodef(http.StatusInternalServerError, "failed to create a cache entry "+
+				"for key %q: %v", key, err)
+		}
+
+		newEntryKey = cachepkg.VariantKey(key, secondaryCacheKey(request, vary))
+		newMetadata.SelectingHeader = selectingHeader(request, vary)
+	}
+
+	spool, err := cachepkg.NewSpool(request.Context(), cache, newEntryKey, newMetadata,
+		upstreamResponse.ContentLength)
+	if err != nil {
+		unlockKey()
+
+		server.recordCacheWriteFailure(newEntryKey, err)
+
+		// The cache entry can't be created, so simply pass the upstream's response
+		// through, stopping the upstream request once the client goes away
+		defer context.AfterFunc(request.Context(), cancelUpstream)()
+
+		if _, err := io.Copy(writer, upstreamResponse.Body); err != nil {
+			return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+				"to the client: %v", err)
+		}
+
+		return responder.NewEmptyf("fetched from the upstream, but failed to create a cache entry "+
+			"for key %q: %v", newEntryKey, err)
+	}
+
+	// Determine whether the fill can continue in the background when the client goes away
+	background := server.canFillInBackground(rule, upstreamResponse)
+
+	// Register an in-flight fill so that the concurrent requests for the same key
+	// don't need to wait for us to finish, with the exception of variants, which
+	// might not be selected by the concurrent requests
+	registered := len(vary) == 0
+
+	var inFlightFill *fill.Fill
+
+	inFlightFill = fill.New(newMetadata, upstreamResponse.ContentLength, spool, fill.WithIdleFunc(func() {
+		server.stopIdleFill(key, inFlightFill, registered, background, cancelUpstream)
+	}))
+
+	// We still hold the fill's reference, so the reader is always available
+	fillReader := inFlightFill.NewReader(request.Context())
+	defer func() {
+		_ = fillReader.Close()
+	}()
+
+	if registered {
+		server.fills.Store(key, inFlightFill)
+	}
+
+	unlockKey()
+
+	handedOver = true
+
+	go server.pumpFill(rule, key, newEntryKey, upstreamResponse, spool, inFlightFill, registered, cancelUpstream)
+
+	if _, err := io.Copy(writer, fillReader); err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+			"to the client: %v", err)
+	}
+
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", "miss"),
+	))
+
+	return responder.NewEmptyf("fetched from the upstream, cache entry is outdated")
+}
+
+// stopIdleFill stops the fill that no one reads anymore (e.g. all the clients have gone away),
+// unless the fill is allowed to continue in the background, in which case its time is limited.
+func (server *Server) stopIdleFill(
+	key string,
+	inFlightFill *fill.Fill,
+	registered bool,
+	background bool,
+	cancelUpstream context.CancelFunc,
+) {
+	if background {
+		time.AfterFunc(server.backgroundFillBudget().timeout, cancelUpstream)
+
+		return
+	}
+
+	// Un-register the fill first, so that the new requests
+	// won't join the fill that is about to fail
+	if registered {
+		server.unregisterFill(key, inFlightFill)
+	}
+
+	cancelUpstream()
+}
+
+// unregisterFill un-registers the fill, unless it was already
+// replaced by the newer fill for the same key.
+func (server *Server) unregisterFill(key string, inFlightFill *fill.Fill) {
+	server.fills.Compute(key, func(registeredFill *fill.Fill, loaded bool) (*fill.Fill, xsync.ComputeOp) {
+		if loaded && registeredFill == inFlightFill {
+			return nil, xsync.DeleteOp
+		}
+
+		return registeredFill, xsync.CancelOp
+	})
+}
+
+// newUpstreamRequest creates a request to the upstream that is
+// conditional on the cache entry's validators, if any.
+func (server *Server) newUpstreamRequest(
+	ctx context.Context,
+	request *http.Request,
+	rule *rulepkg.Rule,
+	metadata cachepkg.Metadata,
+	cacheEntryFound bool,
+) (*http.Request, error) {
+	// Follow the upstream's redirects (e.g. to a pre-signed blob storage URL)
+	// when asked to, so that the final response can be cached under our key
+	if rule != nil && rule.FollowRedirects() {
+		ctx = withFollowRedirects(ctx)
+	}
+
+	// Requests received by the registry mirror endpoints are authenticated with the upstream
+	// registry by us, and the blob storage redirects are always followed so that the blobs
+	// can be cached, since the clients can't tell the upstream registry from the mirror
+	if registryMirror, ok := registryMirrorFromContext(request.Context()); ok {
+		ctx = withRegistryMirror(ctx, registryMirror)
+		ctx = withFollowRedirects(ctx)
+	}
+
 	// According to RFC 9110 "HTTP Semantics", §13.2.1 "When to Evaluate",
 	// this should be safe even when making conditional requests:
 	//
@@ -90,47 +461,86 @@ func (server *Server) handleProxyDefault(writer http.ResponseWriter, request *ht
 	// take precedence over the evaluation of preconditions.
 	//
 	// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.2.1
-	upstreamRequest, err := http.NewRequestWithContext(request.Context(), request.Method, request.URL.String(),
+	upstreamRequest, err := http.NewRequestWithContext(ctx, request.Method, request.URL.String(),
 		request.Body)
 	if err != nil {
-		return responder.NewCodef(http.StatusInternalServerError, "failed to create an upstream request: %v",
-			err)
-	}
-
-	// Try to make the request conditional to save bandwidth and time
-	if eTag := metadata.ETag; eTag != "" {
-		upstreamRequest.Header.Set("If-None-Match", eTag)
+		return nil, err
 	}
 
 	// Propagate our request headers to the upstream's request
-	for key, values := range request.Header {
-		for _, value := range values {
-			upstreamRequest.Header.Set(key, value)
-		}
-	}
+	upstreamRequest.Header = request.Header.Clone()
 
 	// Remove end-to-end headers from the request
 	removeEndToEndHeaders(upstreamRequest.Header)
 
+	// We're revalidating the whole cache entry, so the requested ranges (if any)
+	// will be served from the cache entry itself, and the client's own preconditions
+	// will be evaluated against the cache entry once it's revalidated
+	if cacheEntryFound {
+		upstreamRequest.Header.Del("Range")
+		upstreamRequest.Header.Del("If-Range")
+		upstreamRequest.Header.Del("If-None-Match")
+		upstreamRequest.Header.Del("If-Modified-Since")
+
+		// Try to make the request conditional to save bandwidth and time
+		//
+		// As per RFC 9110 "HTTP Semantics", §13.1.3 "If-Modified-Since"[1], a recipient
+		// MUST ignore If-Modified-Since if the request contains an If-None-Match header
+		// field, so we only fall back to the Last-Modified when there's no ETag.
+		//
+		// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.1.3
+		if eTag := metadata.ETag; eTag != "" {
+			upstreamRequest.Header.Set("If-None-Match", eTag)
+		} else if lastModified := metadata.LastModified; lastModified != "" {
+			upstreamRequest.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
 	server.logger.Debugf("upstream request: %+v", upstreamRequest)
 
-	// Determine the HTTP client to use
-	var httpClient *http.Client
+	return upstreamRequest, nil
+}
 
+// httpClient determines the HTTP client to use for the upstream request.
+func (server *Server) httpClient(upstreamRequest *http.Request, rule *rulepkg.Rule) *http.Client {
 	if server.cluster != nil && server.cluster.ContainsNode(upstreamRequest.URL.Host) {
-		httpClient = server.internalHTTPClient
-	} else {
-		httpClient = server.externalHTTPClient
+		return server.internalHTTPClient
 	}
 
-	// Perform an upstream request
-	upstreamResponse, err := httpClient.Do(upstreamRequest)
-	if err != nil {
-		return responder.NewCodef(http.StatusInternalServerError, "failed to perform a request "+
-			"to the upstream: %v", err)
+	// The rule's upstream settings take precedence over the global ones
+	settings := server.upstream
+
+	if rule != nil {
+		settings = settings.Merge(rule.Upstream())
 	}
-	defer upstreamResponse.Body.Close()
 
+	httpClient, _ := server.upstreamHTTPClients.LoadOrCompute(settings, func() (*http.Client, bool) {
+		var dialContext upstream.DialContextFunc
+
+		// We need this when using direct connect functionality
+		// with direct connect header disabled
+		if server.localNetworkHelper != nil {
+			dialContext = server.localNetworkHelper.PrivilegedDialContext
+		}
+
+		return &http.Client{
+			Transport:     upstream.NewTransport(settings, dialContext),
+			CheckRedirect: checkRedirect,
+		}, false
+	})
+
+	// Authenticate with the upstream registry on behalf of the registry mirror's clients
+	if registryMirror, ok := registryMirrorFromContext(upstreamRequest.Context()); ok {
+		return &http.Client{
+			Transport:     registryMirror.Transport(httpClient.Transport),
+			CheckRedirect: checkRedirect,
+		}
+	}
+
+	return httpClient
+}
+
+func (server *Server) propagateUpstreamResponseHeaders(writer http.ResponseWriter, upstreamResponse *http.Response) {
 	// Remove end-to-end headers from the response
 	removeEndToEndHeaders(upstreamResponse.Header)
 
@@ -145,125 +555,153 @@ func (server *Server) handleProxyDefault(writer http.ResponseWriter, request *ht
 	}
 
 	server.logger.Debugf("upstream response: %+v", upstreamResponse)
+}
 
-	switch {
-	case upstreamResponse.StatusCode == http.StatusOK && server.shouldCache(request, upstreamResponse, rule):
-		// Our cache entry is outdated and caching is allowed, refresh cache entry contents
-		teeReader := io.TeeReader(upstreamResponse.Body, writer)
-
-		err = cache.Put(request.Context(), key, cachepkg.Metadata{
-			ETag: upstreamResponse.Header.Get("ETag"),
-		}, teeReader)
-		if err != nil {
-			return responder.NewCodef(http.StatusInternalServerError, "failed to create a cache entry "+
-				"for key %q: %v", key, err)
-		}
+// respondWithUpstreamResponse passes the upstream's response through to the client.
+func (server *Server) respondWithUpstreamResponse(
+	writer http.ResponseWriter,
+	upstreamResponse *http.Response,
+) responder.Responder {
+	writer.WriteHeader(upstreamResponse.StatusCode)
 
-		// Metrics
-		//nolint:contextcheck // can's use request.Context() here because it might be canceled
-		server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
-			attribute.String("type", "miss"),
-		))
-
-		return responder.NewEmptyf("fetched from the upstream, cache entry is outdated")
-	case upstreamResponse.StatusCode == http.StatusNotModified && cacheEntryReader != nil:
-		// Our cached entry is up-to-date, perform redirection to a Chacha
-		// server holding this cache entry when direct connect is enabled
-		if server.cluster != nil && rule != nil && rule.DirectConnect() {
-			directConnectURL := url.URL{
-				Scheme: "http",
-				Host:   server.cluster.TargetNode(key),
-				Path:   "/direct-connect",
-			}
+	if _, err := io.Copy(writer, upstreamResponse.Body); err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+			"to the client: %v", err)
+	}
 
-			directConnectToken, err := server.generateDirectConnectToken(key)
-			if err != nil {
-				return responder.NewCodef(http.StatusInternalServerError,
-					"failed to generate direct connect token: %v", err)
-			}
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", "not-allowed"),
+	))
 
-			query := directConnectURL.Query()
-			query.Set("token", directConnectToken)
-			directConnectURL.RawQuery = query.Encode()
+	return responder.NewEmptyf("fetched from the upstream, caching is not allowed")
+}
 
-			writer.Header().Set("Location", directConnectURL.String())
+func (server *Server) cacheKey(request *http.Request, rule *rulepkg.Rule) string {
+	// Content-addressed cache key, which allows to share the cache
+	// entries between different URLs pointing to the same contents
+	if rule != nil {
+		if cacheKey, ok := rule.ExpandCacheKey(request.URL.String()); ok {
+			return cacheKey
+		}
+	}
 
-			// Provide a direct connect hint so that the client
-			// can disable the proxy server for faster retrieval
-			if rule.DirectConnectHeader() {
-				writer.Header().Set("X-Chacha-Direct-Connect", "1")
-			}
+	query := request.URL.Query()
 
-			return responder.NewCodef(http.StatusTemporaryRedirect, "redirected with a direct connect hint")
+	if rule != nil {
+		for _, ignoredParameter := range rule.IgnoredParameters() {
+			query.Del(ignoredParameter)
 		}
+	}
 
-		// Otherwise return the cache entry contents
-		writer.WriteHeader(http.StatusOK)
-
-		copyStartAt := time.Now()
+	cacheURL := url.URL{
+		Scheme:   request.URL.Scheme,
+		Host:     request.URL.Host,
+		Path:     request.URL.Path,
+		RawQuery: query.Encode(),
+	}
 
-		n, err := io.Copy(writer, cacheEntryReader)
-		if err != nil {
-			return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
-				"to the client: %v", err)
-		}
+	return cacheURL.String()
+}
 
-		// Metrics
-		//nolint:contextcheck // can's use request.Context() here because it might be canceled
-		server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
-			attribute.String("type", "hit"),
-		))
+// openCacheEntry opens the cache entry and determines its size.
+//
+// HEAD requests are answered from the cache entry's metadata
+// only, so there's no need to open the cache entry's blob.
+func openCacheEntry(
+	request *http.Request,
+	cache cachepkg.Cache,
+	key string,
+) (io.ReadSeekCloser, int64, cachepkg.Metadata, error) {
+	if request.Method == http.MethodHead {
+		size, metadata, err := cache.Head(request.Context(), key)
+
+		return nil, size, metadata, err
+	}
 
-		bytesPerSecond := float64(n) / max(time.Since(copyStartAt).Seconds(), 1)
+	cacheEntryReader, metadata, err := cache.Get(request.Context(), key)
+	if err != nil {
+		return nil, 0, cachepkg.Metadata{}, err
+	}
 
-		//nolint:contextcheck // can's use request.Context() here because it might be canceled
-		server.cacheSpeedHistogram.Record(context.Background(), int64(bytesPerSecond), metric.WithAttributes(
-			attribute.String("type", "hit"),
-		))
+	size, err := cacheEntryReader.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = cacheEntryReader.Close()
 
-		return responder.NewEmptyf("retrieved from the cache")
-	default:
-		// Caching is not allowed
-		writer.WriteHeader(upstreamResponse.StatusCode)
+		return nil, 0, cachepkg.Metadata{}, fmt.Errorf("failed to determine "+
+			"the cache entry size: %w", err)
+	}
 
-		if _, err := io.Copy(writer, upstreamResponse.Body); err != nil {
-			return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
-				"to the client: %v", err)
-		}
+	return cacheEntryReader, size, metadata, nil
+}
 
-		// Metrics
-		//nolint:contextcheck // can's use request.Context() here because it might be canceled
-		server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
-			attribute.String("type", "not-allowed"),
-		))
+// secondaryCacheKey calculates the secondary cache key from the values
+// of the request header fields that the upstream's response varies on.
+func secondaryCacheKey(request *http.Request, vary []string) string {
+	values := url.Values{}
 
-		return responder.NewEmptyf("fetched from the upstream, caching is not allowed")
+	for _, field := range vary {
+		if fieldValues := request.Header.Values(field); len(fieldValues) != 0 {
+			values.Set(strings.ToLower(field), normalizeFieldValue(strings.Join(fieldValues, ",")))
+		}
 	}
+
+	return values.Encode()
 }
 
-func (server *Server) cacheKey(request *http.Request, rule *rulepkg.Rule) string {
-	scheme := "http"
+// selectingHeader returns the request header fields that the upstream's response varies on.
+func selectingHeader(request *http.Request, vary []string) http.Header {
+	result := http.Header{}
 
-	if request.TLS != nil {
-		scheme = "https"
+	for _, field := range vary {
+		if fieldValues := request.Header.Values(field); len(fieldValues) != 0 {
+			result[field] = slices.Clone(fieldValues)
+		}
 	}
 
-	query := request.URL.Query()
+	return result
+}
 
-	if rule != nil {
-		for _, ignoredParameter := range rule.IgnoredParameters() {
-			query.Del(ignoredParameter)
+// responseVary returns the canonicalized and sorted list of the request header fields
+// listed in the upstream's response Vary header, with the second return value being
+// false when the response can never be matched (e.g. "Vary: *").
+func responseVary(header http.Header) ([]string, bool) {
+	var result []string
+
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+
+			switch field {
+			case "":
+				continue
+			case "*":
+				return nil, false
+			}
+
+			result = append(result, http.CanonicalHeaderKey(field))
 		}
 	}
 
-	cacheURL := url.URL{
-		Scheme:   scheme,
-		Host:     request.Host,
-		Path:     request.URL.Path,
-		RawQuery: query.Encode(),
+	slices.Sort(result)
+
+	return slices.Compact(result), true
+}
+
+// normalizeFieldValue normalizes the field value by removing the whitespace
+// around the list elements, as permitted by RFC 9111 "HTTP Caching",
+// §4.1 "Calculating Cache Keys with the Vary Header Field"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-4.1
+func normalizeFieldValue(value string) string {
+	elements := strings.Split(value, ",")
+
+	for i, element := range elements {
+		elements[i] = strings.TrimSpace(element)
 	}
 
-	return cacheURL.String()
+	return strings.Join(elements, ",")
 }
 
 func (server *Server) cache(key string) cachepkg.Cache {
@@ -289,10 +727,6 @@ func (server *Server) shouldCache(request *http.Request, response *http.Response
 		return false
 	}
 
-	if strings.Contains(request.Header.Get("Pragma"), "no-cache") {
-		return false
-	}
-
 	if !isCacheableCacheControl(response.Header.Values("Cache-Control")) {
 		return false
 	}
@@ -305,15 +739,15 @@ func (server *Server) shouldCache(request *http.Request, response *http.Response
 
 	// A Vary header field-value of "*" always fails to match.[1]
 	//
-	// However, since the Vary header is almost never used in our use-case,
-	// we simplify things and always fail to match when this header is set.
-	//
-	// [1]: https://datatracker.ietf.org/doc/html/rfc7234#section-4.1
-	if len(response.Header.Values("Vary")) != 0 {
+	// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-4.1
+	if _, ok := responseVary(response.Header); !ok {
 		return false
 	}
 
-	if response.StatusCode != http.StatusOK {
+	// Objects that are known to be larger than allowed are not cached,
+	// objects of unknown size are checked when writing the cache entry
+	if maxSize := rule.MaxSize(); maxSize != 0 && response.ContentLength > 0 &&
+		uint64(response.ContentLength) > maxSize {
 		return false
 	}
 
@@ -364,6 +798,22 @@ func headersContainDirective(headers []string, directive string) bool {
 	return false
 }
 
+func headersDirectiveValue(headers []string, directive string) (string, bool) {
+	for _, header := range headers {
+		headerDirectives := strings.Split(header, ",")
+
+		for _, headerDirective := range headerDirectives {
+			directiveKey, directiveValue, _ := strings.Cut(headerDirective, "=")
+
+			if strings.EqualFold(strings.TrimSpace(directiveKey), directive) {
+				return strings.Trim(strings.TrimSpace(directiveValue), `"`), true
+			}
+		}
+	}
+
+	return "", false
+}
+
 func removeEndToEndHeaders(header http.Header) {
 	// Remove Connection header as per RFC 9110, §7.6.1 "Connection"[1]
 	//
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/cirruslabs/chacha/internal/server/fill"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"net/http"
+	"time"
+)
+
+// respondFromFill serves the request from the in-flight fill, which allows the concurrent
+// requests for the same key to stream the upstream's response as it arrives.
+//
+// Each request still needs to be authorized by the upstream, so we revalidate the
+// fill's contents using its validators and only serve from the fill on 304.
+func (server *Server) respondFromFill(
+	writer http.ResponseWriter,
+	request *http.Request,
+	rule *rulepkg.Rule,
+	key string,
+	inFlightFill *fill.Fill,
+	fillReader *fill.Reader,
+) responder.Responder {
+	metadata := inFlightFill.Metadata()
+
+	// Without validators, the upstream can't tell us whether
+	// the fill's contents are suitable for this request
+	canRevalidate := metadata.ETag != "" || metadata.LastModified != ""
+
+	upstreamRequest, err := server.newUpstreamRequest(request.Context(), request, rule, metadata, canRevalidate)
+	if err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to create an upstream request: %v",
+			err)
+	}
+
+	upstreamResponse, err := server.doUpstreamRequest(upstreamRequest, rule)
+	if err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to perform a request "+
+			"to the upstream: %v", err)
+	}
+	defer upstreamResponse.Body.Close()
+
+	server.propagateUpstreamResponseHeaders(writer, upstreamResponse)
+
+	if !canRevalidate || upstreamResponse.StatusCode != http.StatusNotModified {
+		return server.respondWithUpstreamResponse(writer, upstreamResponse)
+	}
+
+	// The fill's contents are up-to-date, however, the 304 response
+	// usually lacks most of the headers, so add the stored ones
+	updateCacheEntryHeaders(writer.Header(), metadata)
+
+	// The client already has an up-to-date representation
+	if isNotModified(request, metadata) {
+		//nolint:contextcheck // can's use request.Context() here because it might be canceled
+		server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("type", "coalesced-hit"),
+		))
+
+		return responder.NewCodef(http.StatusNotModified, "client's representation is up-to-date "+
+			"(coalesced-hit)")
+	}
+
+	// Serving the fill requires knowing its size in advance,
+	// so wait for the fill to finish if the upstream hasn't
+	// provided the Content-Length
+	size, err := inFlightFill.Size()
+	if errors.Is(err, fill.ErrUnknownSize) {
+		if err := inFlightFill.Wait(request.Context()); err != nil {
+			return responder.NewCodef(http.StatusBadGateway, "failed to fill the cache entry "+
+				"for key %q: %v", key, err)
+		}
+
+		size, err = inFlightFill.Size()
+	}
+	if err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to determine the fill size "+
+			"for key %q: %v", key, err)
+	}
+
+	copyStartAt := time.Now()
+
+	n, errResponder := server.serveCacheEntry(writer, request, fillReader, size, metadata)
+	if errResponder != nil {
+		return errResponder
+	}
+
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", "coalesced-hit"),
+	))
+
+	bytesPerSecond := float64(n) / max(time.Since(copyStartAt).Seconds(), 1)
+
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheSpeedHistogram.Record(context.Background(), int64(bytesPerSecond), metric.WithAttributes(
+		attribute.String("type", "coalesced-hit"),
+	))
+
+	return responder.NewEmptyf("retrieved from the in-flight fill")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxNegativeCacheEntrySize is the maximum size of the negative response's body
+// (e.g. an error page) that we store, larger negative responses are not cached.
+const maxNegativeCacheEntrySize = 64 * 1024
+
+// isNegativeStatusCode determines whether the upstream's response
+// indicates that the requested resource doesn't exist.
+func isNegativeStatusCode(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+// respondWithNegativeCacheEntry stores the upstream's negative response as
+// a cache entry, which is then served for the rule's negative TTL without
+// contacting the upstream, and passes the response through to the client.
+func (server *Server) respondWithNegativeCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	cache cachepkg.Cache,
+	key string,
+	upstreamResponse *http.Response,
+) responder.Responder {
+	body, err := io.ReadAll(io.LimitReader(upstreamResponse.Body, maxNegativeCacheEntrySize+1))
+	if err != nil {
+		return responder.NewCodef(http.StatusBadGateway, "failed to read the upstream's response: %v", err)
+	}
+
+	operationType := "negative-miss"
+
+	if len(body) <= maxNegativeCacheEntrySize {
+		// Validators are not stored, so that the negative cache entry
+		// is never revalidated and is simply re-fetched once expired
+		metadata := cachepkg.Metadata{
+			StatusCode: upstreamResponse.StatusCode,
+			FetchedAt:  time.Now().UTC(),
+			Header:     storableHeader(upstreamResponse.Header),
+		}
+
+		if err := cache.Put(request.Context(), key, metadata, bytes.NewReader(body), int64(len(body))); err != nil {
+			operationType = "write-failed"
+
+			server.logger.Warnf("failed to create a negative cache entry for key %q: %v", key, err)
+		}
+	} else {
+		operationType = "too-large"
+	}
+
+	writer.WriteHeader(upstreamResponse.StatusCode)
+
+	if _, err := io.Copy(writer, io.MultiReader(bytes.NewReader(body), upstreamResponse.Body)); err != nil {
+		return responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+			"to the client: %v", err)
+	}
+
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", operationType),
+	))
+
+	return responder.NewEmptyf("fetched from the upstream, negative cache entry is outdated")
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	"net/http"
+)
+
+type registryMirrorKey struct{}
+
+// withRegistryMirror marks the request's context as belonging
+// to the request received by the registry mirror endpoint.
+func withRegistryMirror(ctx context.Context, registryMirror *registrymirror.RegistryMirror) context.Context {
+	return context.WithValue(ctx, registryMirrorKey{}, registryMirror)
+}
+
+func registryMirrorFromContext(ctx context.Context) (*registrymirror.RegistryMirror, bool) {
+	registryMirror, ok := ctx.Value(registryMirrorKey{}).(*registrymirror.RegistryMirror)
+
+	return registryMirror, ok
+}
+
+func (server *Server) newRegistryMirrorEndpoint(registryMirror *registrymirror.RegistryMirror) (*endpoint, error) {
+	return server.newEndpoint(registryMirror.Addr(), "registry mirror for "+registryMirror.Upstream(),
+		func(writer http.ResponseWriter, request *http.Request) (responder.Responder, string) {
+			return server.handleRegistryMirror(writer, request, registryMirror)
+		})
+}
+
+// handleRegistryMirror serves the pull requests received by the registry mirror endpoint,
+// as described in the OCI Distribution Specification[1], from the upstream registry.
+//
+// [1]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pull
+func (server *Server) handleRegistryMirror(
+	writer http.ResponseWriter,
+	request *http.Request,
+	registryMirror *registrymirror.RegistryMirror,
+) (responder.Responder, string) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return responder.NewCodef(http.StatusMethodNotAllowed, "registry mirror only supports pulls"),
+			"registry-mirror-unknown"
+	}
+
+	// API version check, which the clients perform before pulling
+	if request.URL.Path == "/v2/" || request.URL.Path == "/v2" {
+		writer.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+		return responder.NewCodef(http.StatusOK, "registry mirror is available"),
+			"registry-mirror-version-check"
+	}
+
+	upstreamURL, ok := registryMirror.UpstreamURL(request)
+	if !ok {
+		return responder.NewCodef(http.StatusNotFound, "not a manifest or a blob pull"),
+			"registry-mirror-unknown"
+	}
+
+	// Map the request to the upstream registry, after which
+	// it's handled just like the proxied request to it
+	request = request.WithContext(withRegistryMirror(request.Context(), registryMirror))
+	request.URL = upstreamURL
+	request.Host = upstreamURL.Host
+
+	// We authenticate with the upstream registry ourselves
+	request.Header.Del("Authorization")
+
+	return server.proxyRequest(writer, request), "registry-mirror"
+}
@@ -0,0 +1,233 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httprange implements parsing of the Range header and serving
+// of the partial content as per RFC 9110, §14 "Range Requests"[1].
+//
+// Most of the code is derived from Go's net/http/fs.go, which
+// unfortunately doesn't export its range-handling primitives.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-14
+package httprange
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrNoOverlap is returned by Parse if first-byte-pos of
+// all of the byte-range-spec values is greater than the content size.
+var ErrNoOverlap = errors.New("invalid range: failed to overlap")
+
+// Range specifies the byte range to be sent to the client.
+type Range struct {
+	Start, Length int64
+}
+
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+func (r Range) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.ContentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// Parse parses a Range header string as per RFC 9110.
+// ErrNoOverlap is returned if none of the ranges overlap.
+func Parse(s string, size int64) ([]Range, error) {
+	if s == "" {
+		return nil, nil // header not present
+	}
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errors.New("invalid range")
+	}
+	var ranges []Range
+	noOverlap := false
+	for ra := range strings.SplitSeq(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errors.New("invalid range")
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+		var r Range
+		if start == "" {
+			// If no start is specified, end specifies the
+			// range start relative to the end of the file,
+			// and we are dealing with <suffix-length>
+			// which has to be a non-negative integer as per
+			// RFC 9110 Section 14.1.1 "Range Specifiers".
+			if end == "" || end[0] == '-' {
+				return nil, errors.New("invalid range")
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errors.New("invalid range")
+			}
+			if i > size {
+				i = size
+			}
+			r.Start = size - i
+			r.Length = size - r.Start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if i >= size {
+				// If the range begins after the size of the content,
+				// then it does not overlap.
+				noOverlap = true
+				continue
+			}
+			r.Start = i
+			if end == "" {
+				// If no end is specified, range extends to end of the file.
+				r.Length = size - r.Start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.Start > i {
+					return nil, errors.New("invalid range")
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.Length = i - r.Start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		// The specified ranges did not overlap with the content.
+		return nil, ErrNoOverlap
+	}
+	if sumRangesSize(ranges) > size {
+		// The total number of bytes in all the ranges
+		// is larger than the size of the file by
+		// itself, so this is probably an attack, or a
+		// dumb client. Ignore the range request.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// Serve writes the requested ranges of the content to the writer,
+// or the whole content if no ranges were requested. The content
+// can be nil when serving HEAD requests.
+//
+// The number of content bytes written is returned.
+func Serve(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, size int64, ranges []Range) (int64, error) {
+	code := http.StatusOK
+	ctype := w.Header().Get("Content-Type")
+
+	sendSize := size
+	var sendContent io.Reader = content
+
+	switch {
+	case len(ranges) == 1:
+		// RFC 9110, Section 14.2:
+		// "A server MUST NOT generate a multipart response to
+		// a request for a single range, since a client that
+		// does not request multiple parts might not support
+		// multipart responses."
+		ra := ranges[0]
+		if content != nil {
+			if _, err := content.Seek(ra.Start, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+		sendSize = ra.Length
+		code = http.StatusPartialContent
+		w.Header().Set("Content-Range", ra.ContentRange(size))
+	case len(ranges) > 1:
+		sendSize = rangesMIMESize(ranges, ctype, size)
+		code = http.StatusPartialContent
+
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		sendContent = pr
+		defer pr.Close() // cause writing goroutine to fail and exit if CopyN doesn't finish.
+		if r.Method == http.MethodHead || content == nil {
+			break
+		}
+		go func() {
+			for _, ra := range ranges {
+				part, err := mw.CreatePart(ra.mimeHeader(ctype, size))
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := content.Seek(ra.Start, io.SeekStart); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := io.CopyN(part, content, ra.Length); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			mw.Close()
+			pw.Close()
+		}()
+	default:
+		if content != nil {
+			if _, err := content.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
+	w.WriteHeader(code)
+
+	if r.Method == http.MethodHead || content == nil {
+		return 0, nil
+	}
+
+	return io.CopyN(w, sendContent, sendSize)
+}
+
+// countingWriter counts how many bytes have been written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// rangesMIMESize returns the number of bytes it takes to encode the
+// provided ranges as a multipart response.
+func rangesMIMESize(ranges []Range, contentType string, contentSize int64) (encSize int64) {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	for _, ra := range ranges {
+		mw.CreatePart(ra.mimeHeader(contentType, contentSize))
+		encSize += ra.Length
+	}
+	mw.Close()
+	encSize += int64(w)
+	return
+}
+
+func sumRangesSize(ranges []Range) (size int64) {
+	for _, ra := range ranges {
+		size += ra.Length
+	}
+	return
+}
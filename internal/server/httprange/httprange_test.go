@@ -0,0 +1,38 @@
+package httprange_test
+
+import (
+	"github.com/cirruslabs/chacha/internal/server/httprange"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected []httprange.Range
+	}{
+		{"", nil},
+		{"bytes=0-4", []httprange.Range{{Start: 0, Length: 5}}},
+		{"bytes=5-", []httprange.Range{{Start: 5, Length: 5}}},
+		{"bytes=-3", []httprange.Range{{Start: 7, Length: 3}}},
+		{"bytes=0-100", []httprange.Range{{Start: 0, Length: 10}}},
+		{"bytes=0-1, 4-5", []httprange.Range{{Start: 0, Length: 2}, {Start: 4, Length: 2}}},
+	}
+
+	for _, testCase := range testCases {
+		ranges, err := httprange.Parse(testCase.header, 10)
+		require.NoError(t, err, testCase.header)
+		require.Equal(t, testCase.expected, ranges, testCase.header)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, header := range []string{"bits=0-1", "bytes=a-b", "bytes=5-1", "bytes=--1"} {
+		_, err := httprange.Parse(header, 10)
+		require.Error(t, err, header)
+		require.NotErrorIs(t, err, httprange.ErrNoOverlap, header)
+	}
+
+	_, err := httprange.Parse("bytes=10-", 10)
+	require.ErrorIs(t, err, httprange.ErrNoOverlap)
+}
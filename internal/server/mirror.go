@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"net/http"
+	"net/url"
+)
+
+type mirroredURLKey struct{}
+
+// doUpstreamRequest performs the upstream request, trying the rule's mirrors (if any) in order
+// before contacting the origin, with the next one being tried when the current one is not
+// available (connection error or HTTP 5xx).
+//
+// The responses from mirrors are treated as if they were served by the origin,
+// so the cache entries stay the same regardless of where they were fetched from.
+func (server *Server) doUpstreamRequest(upstreamRequest *http.Request, rule *rulepkg.Rule) (*http.Response, error) {
+	if rule != nil && canUseMirrors(upstreamRequest) {
+		for _, mirrorURL := range rule.MirrorURLs(upstreamRequest.URL.String()) {
+			mirrorRequest, err := newMirrorRequest(upstreamRequest, mirrorURL)
+			if err != nil {
+				server.logger.Warnf("failed to create a request to mirror %s: %v", mirrorURL, err)
+
+				continue
+			}
+
+			mirrorResponse, err := server.httpClient(mirrorRequest, rule).Do(mirrorRequest)
+			if err != nil {
+				// No point in trying other mirrors when the request is no longer needed
+				if upstreamRequest.Context().Err() != nil {
+					return nil, err
+				}
+
+				server.logger.Warnf("failed to perform a request to mirror %s, trying the next one: %v",
+					mirrorURL, err)
+
+				continue
+			}
+
+			if mirrorResponse.StatusCode >= 500 {
+				_ = mirrorResponse.Body.Close()
+
+				server.logger.Warnf("mirror %s responded with HTTP %d, trying the next one",
+					mirrorURL, mirrorResponse.StatusCode)
+
+				continue
+			}
+
+			return mirrorResponse, nil
+		}
+	}
+
+	return server.httpClient(upstreamRequest, rule).Do(upstreamRequest)
+}
+
+// canUseMirrors returns whether the upstream request can be sent to
+// multiple upstreams, which is only possible when it has no body.
+func canUseMirrors(upstreamRequest *http.Request) bool {
+	if upstreamRequest.Method != http.MethodGet && upstreamRequest.Method != http.MethodHead {
+		return false
+	}
+
+	return upstreamRequest.Body == nil || upstreamRequest.Body == http.NoBody
+}
+
+// newMirrorRequest creates a copy of the upstream request that is sent to the mirror instead,
+// remembering the origin's URL for the places where the response's URL matters (e.g. rules).
+//
+// The credentials that the client meant for the origin are not sent to the mirror, similarly
+// to how net/http strips them when following a redirect to a different host.
+func newMirrorRequest(upstreamRequest *http.Request, mirrorURL string) (*http.Request, error) {
+	parsedMirrorURL, err := url.Parse(mirrorURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(upstreamRequest.Context(), mirroredURLKey{}, upstreamRequest.URL.String())
+
+	mirrorRequest := upstreamRequest.Clone(ctx)
+	mirrorRequest.URL = parsedMirrorURL
+	mirrorRequest.Host = parsedMirrorURL.Host
+
+	for _, header := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+		mirrorRequest.Header.Del(header)
+	}
+
+	return mirrorRequest, nil
+}
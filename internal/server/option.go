@@ -3,10 +3,13 @@ package server
 import (
 	cachepkg "github.com/cirruslabs/chacha/internal/cache"
 	"github.com/cirruslabs/chacha/internal/server/cluster"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
 	"github.com/cirruslabs/chacha/internal/server/rule"
 	"github.com/cirruslabs/chacha/internal/server/tlsinterceptor"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
 	"github.com/cirruslabs/chacha/pkg/localnetworkhelper"
 	"go.uber.org/zap"
+	"time"
 )
 
 type Option func(server *Server)
@@ -41,6 +44,58 @@ func WithLocalNetworkHelper(localNetworkHelper *localnetworkhelper.LocalNetworkH
 	}
 }
 
+// WithBackgroundFill enables finishing the cache entry fills in the background
+// when the client goes away, for the responses of up to maxSizeBytes in size
+// and for up to timeout after the client went away. Zero values mean the defaults
+// of 10 GB and 1 hour, which also apply when the background fill is only enabled per rule.
+func WithBackgroundFill(maxSizeBytes uint64, timeout time.Duration) Option {
+	return func(server *Server) {
+		server.backgroundFill = &backgroundFill{
+			maxSizeBytes: maxSizeBytes,
+			timeout:      timeout,
+		}
+	}
+}
+
+// WithUpstream configures the timeouts and retries of the upstream requests,
+// which can be overridden per rule.
+func WithUpstream(settings upstream.Settings) Option {
+	return func(server *Server) {
+		server.upstream = settings
+	}
+}
+
+// WithRegistryMirrors enables the pull-through registry mirror endpoints, each
+// listening on its own address and serving the pulls from its upstream registry.
+func WithRegistryMirrors(registryMirrors ...*registrymirror.RegistryMirror) Option {
+	return func(server *Server) {
+		server.registryMirrors = registryMirrors
+	}
+}
+
+// WithBazel enables the Bazel's HTTP remote cache endpoint listening on the addr, with the
+// requests authenticated using the token, unless it's empty.
+func WithBazel(addr string, token string) Option {
+	return func(server *Server) {
+		server.bazel = &bazel{
+			addr:  addr,
+			token: token,
+		}
+	}
+}
+
+// WithGradle enables the Gradle's HTTP build cache endpoint listening on the addr, with the
+// requests authenticated using the basic auth credentials, unless they're empty.
+func WithGradle(addr string, username string, password string) Option {
+	return func(server *Server) {
+		server.gradle = &gradle{
+			addr:     addr,
+			username: username,
+			password: password,
+		}
+	}
+}
+
 func WithLogger(logger *zap.SugaredLogger) Option {
 	return func(server *Server) {
 		server.logger = logger
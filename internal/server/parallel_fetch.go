@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/cirruslabs/chacha/internal/server/fill"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// minParallelSegmentSize is the minimum size of a single range request
+// when fetching from the upstream in parallel, smaller responses are
+// fetched in fewer segments or using a single request.
+const minParallelSegmentSize = 16 * 1024 * 1024
+
+type parallelSegment struct {
+	start int64
+	end   int64
+}
+
+// parallelSegments splits the upstream's response into up to the rule's number of segments
+// to be fetched using concurrent range requests, if the upstream supports range requests,
+// provides a validator for If-Range and the response is large enough to be worth splitting.
+func parallelSegments(upstreamResponse *http.Response, rule *rulepkg.Rule) ([]parallelSegment, string, bool) {
+	parallelism := rule.ParallelFetch()
+	size := upstreamResponse.ContentLength
+
+	if parallelism < 2 || size <= 0 || upstreamResponse.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, "", false
+	}
+
+	validator, ok := ifRangeValidator(upstreamResponse.Header)
+	if !ok {
+		return nil, "", false
+	}
+
+	numSegments := min(int64(parallelism), size/minParallelSegmentSize)
+	if numSegments < 2 {
+		return nil, "", false
+	}
+
+	segmentSize := (size + numSegments - 1) / numSegments
+
+	var segments []parallelSegment
+
+	for start := int64(0); start < size; start += segmentSize {
+		segments = append(segments, parallelSegment{
+			start: start,
+			end:   min(start+segmentSize, size),
+		})
+	}
+
+	return segments, validator, true
+}
+
+// fetchInParallel fetches the upstream's response using concurrent range requests, with the
+// segments being written directly into the fill at their offsets as soon as they arrive.
+//
+// The first segment is read from the original upstream's response, which
+// is then closed, while the rest of the segments are fetched separately.
+func (server *Server) fetchInParallel(
+	upstreamResponse *http.Response,
+	rule *rulepkg.Rule,
+	segments []parallelSegment,
+	validator string,
+	inFlightFill *fill.Fill,
+) (int64, error) {
+	ctx, cancel := context.WithCancel(upstreamResponse.Request.Context())
+	defer cancel()
+
+	httpClient := server.httpClient(upstreamResponse.Request, rule)
+
+	// Stop fetching the rest of the segments once any of them fails
+	var fetchErr error
+	var fetchErrOnce sync.Once
+
+	fail := func(segment parallelSegment, err error) {
+		fetchErrOnce.Do(func() {
+			fetchErr = fmt.Errorf("failed to fetch bytes %d-%d: %w", segment.start, segment.end-1, err)
+
+			cancel()
+
+			// Unblock the first segment's reader, if it's still running
+			_ = upstreamResponse.Body.Close()
+		})
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(len(segments))
+
+	go func() {
+		defer wg.Done()
+
+		if err := fillSegment(inFlightFill, segments[0], upstreamResponse.Body); err != nil {
+			fail(segments[0], err)
+		}
+	}()
+
+	for _, segment := range segments[1:] {
+		go func() {
+			defer wg.Done()
+
+			if err := fetchSegment(ctx, httpClient, upstreamResponse.Request, validator, inFlightFill,
+				segment); err != nil {
+				fail(segment, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if fetchErr != nil {
+		return 0, fetchErr
+	}
+
+	return upstreamResponse.ContentLength, nil
+}
+
+// fetchRemaining fetches the rest of the upstream's response following the fill's
+// contiguous contents using a single range request, e.g. once the fill's spool fails
+// and the segments that arrive out of order can no longer be written.
+func (server *Server) fetchRemaining(
+	upstreamResponse *http.Response,
+	rule *rulepkg.Rule,
+	validator string,
+	inFlightFill *fill.Fill,
+) (int64, error) {
+	segment := parallelSegment{
+		start: inFlightFill.Written(),
+		end:   upstreamResponse.ContentLength,
+	}
+
+	if segment.start != segment.end {
+		if err := fetchSegment(upstreamResponse.Request.Context(), server.httpClient(upstreamResponse.Request, rule),
+			upstreamResponse.Request, validator, inFlightFill, segment); err != nil {
+			return 0, fmt.Errorf("failed to fetch bytes %d-%d: %w", segment.start, segment.end-1, err)
+		}
+	}
+
+	return upstreamResponse.ContentLength, nil
+}
+
+// fetchSegment performs a range request for the segment and fills it.
+func fetchSegment(
+	ctx context.Context,
+	httpClient *http.Client,
+	upstreamRequest *http.Request,
+	validator string,
+	inFlightFill *fill.Fill,
+	segment parallelSegment,
+) error {
+	request := upstreamRequest.Clone(ctx)
+
+	// Our own revalidation preconditions are irrelevant here
+	request.Header.Del("If-None-Match")
+	request.Header.Del("If-Modified-Since")
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", segment.start, segment.end-1))
+	request.Header.Set("If-Range", validator)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("upstream responded with HTTP %d instead of HTTP %d",
+			response.StatusCode, http.StatusPartialContent)
+	}
+
+	if start, ok := contentRangeStart(response.Header.Get("Content-Range")); !ok || start != segment.start {
+		return fmt.Errorf("upstream responded with an unexpected Content-Range %q",
+			response.Header.Get("Content-Range"))
+	}
+
+	return fillSegment(inFlightFill, segment, response.Body)
+}
+
+// fillSegment copies the segment's contents from src to the fill at the segment's offset.
+func fillSegment(inFlightFill *fill.Fill, segment parallelSegment, src io.Reader) error {
+	n, err := io.Copy(io.NewOffsetWriter(inFlightFill, segment.start), io.LimitReader(src, segment.end-segment.start))
+	if err != nil {
+		return err
+	}
+
+	if n != segment.end-segment.start {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
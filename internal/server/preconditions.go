@@ -0,0 +1,76 @@
+package server
+
+import (
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"net/http"
+	"strings"
+)
+
+// isNotModified evaluates the client's own If-None-Match and If-Modified-Since
+// preconditions against the cache entry, as per RFC 9110 "HTTP Semantics",
+// §13.2.2 "Precedence of Preconditions"[1], and determines whether the client's
+// stored representation is up-to-date, which allows to answer with 304.
+//
+// Note that the client's preconditions are never sent to the upstream when
+// revalidating the cache entry, since we revalidate it using our own validators.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.2.2
+func isNotModified(request *http.Request, metadata cachepkg.Metadata) bool {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return false
+	}
+
+	// Preconditions only apply to the successful responses
+	if metadata.StatusCode != 0 {
+		return false
+	}
+
+	if ifNoneMatch := request.Header.Values("If-None-Match"); len(ifNoneMatch) != 0 {
+		return eTagListMatches(ifNoneMatch, metadata.ETag)
+	}
+
+	// A recipient MUST ignore If-Modified-Since if the request contains an If-None-Match
+	// header field, see RFC 9110 "HTTP Semantics", §13.1.3 "If-Modified-Since"[1].
+	//
+	// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.1.3
+	ifModifiedSince := request.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" || metadata.LastModified == "" {
+		return false
+	}
+
+	ifModifiedSinceTime, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	lastModifiedTime, err := http.ParseTime(metadata.LastModified)
+	if err != nil {
+		return false
+	}
+
+	return !lastModifiedTime.After(ifModifiedSinceTime)
+}
+
+// eTagListMatches performs the weak comparison of the entity-tags in the If-None-Match
+// header field values, as per RFC 9110 "HTTP Semantics", §8.8.3.2 "Comparison"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-8.8.3.2
+func eTagListMatches(values []string, eTag string) bool {
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+
+			// "*" matches any current representation,
+			// even the one that has no entity-tag
+			if candidate == "*" {
+				return true
+			}
+
+			if eTag != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(eTag, "W/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/digest"
+	"github.com/cirruslabs/chacha/internal/server/fill"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"io"
+	"net/http"
+	"time"
+)
+
+// pumpFill copies the upstream's response to the fill independently of the fill's readers
+// and commits the cache entry once the response is complete, fits the rule's maximum size
+// and matches the rule's expected digest and the upstream's Docker-Content-Digest.
+//
+// Only the upstream failures fail the fill, while the cache entry that can't be written
+// or committed (e.g. due to the disk being full or a digest mismatch) is simply abandoned,
+// with the fill passing the rest of the upstream's response through to its readers.
+func (server *Server) pumpFill(
+	rule *rulepkg.Rule,
+	key string,
+	entryKey string,
+	upstreamResponse *http.Response,
+	spool cachepkg.Spool,
+	inFlightFill *fill.Fill,
+	registered bool,
+	cancelUpstream context.CancelFunc,
+) {
+	defer cancelUpstream()
+	defer upstreamResponse.Body.Close()
+
+	copyStartAt := time.Now()
+
+	n, err := server.copyToFill(rule, entryKey, upstreamResponse, spool, inFlightFill)
+
+	var commitErr error
+
+	switch {
+	case errors.Is(err, digest.ErrMismatch):
+		// The readers have received the full contents, they're just not cacheable
+		commitErr, err = err, nil
+	case inFlightFill.SpoolErr() != nil:
+		// The readers have received the contents passed through, if any
+		commitErr = inFlightFill.SpoolErr()
+	case err != nil:
+		server.logger.Warnf("failed to fill the cache entry for key %q: %v", entryKey, err)
+	case rule.MaxSize() != 0 && uint64(n) > rule.MaxSize():
+		commitErr = fmt.Errorf("%w: cache entry is larger than the allowed maximum size",
+			cachepkg.ErrTooLarge)
+	default:
+		commitErr = spool.Commit(server.backgroundCtx, n)
+	}
+
+	if err == nil {
+		server.recordMissSpeed(n, copyStartAt)
+	}
+
+	if commitErr != nil {
+		server.recordCacheWriteFailure(entryKey, commitErr)
+	}
+
+	// Un-register the fill first, so that the new requests
+	// would use the cache entry instead of the finished fill
+	if registered {
+		server.unregisterFill(key, inFlightFill)
+	}
+
+	inFlightFill.Finish(err)
+
+	if err := inFlightFill.Release(); err != nil {
+		server.logger.Warnf("failed to release a fill for key %q: %v", key, err)
+	}
+}
+
+// copyToFill copies the upstream's response to the fill, either sequentially or using
+// the concurrent range requests, when possible, and verifies its digest, if needed.
+func (server *Server) copyToFill(
+	rule *rulepkg.Rule,
+	entryKey string,
+	upstreamResponse *http.Response,
+	spool cachepkg.Spool,
+	inFlightFill *fill.Fill,
+) (int64, error) {
+	segments, validator, ok := parallelSegments(upstreamResponse, rule)
+	if !ok {
+		return io.Copy(inFlightFill, server.verifyingReader(entryKey, upstreamResponse.Body, rule,
+			upstreamResponse))
+	}
+
+	n, err := server.fetchInParallel(upstreamResponse, rule, segments, validator, inFlightFill)
+	if err != nil && inFlightFill.SpoolErr() != nil {
+		// The contents can only be passed through in order,
+		// so fetch the rest of them using a single request
+		n, err = server.fetchRemaining(upstreamResponse, rule, validator, inFlightFill)
+	}
+	if err != nil {
+		return n, err
+	}
+
+	// The segments arrive out of order, so the digest can only be verified
+	// once all of them are written, which is pointless without a cache entry
+	if rule.VerifyDigest() != "" && inFlightFill.SpoolErr() == nil {
+		if _, err := io.Copy(io.Discard, server.verifyingReader(entryKey, io.NewSectionReader(spool, 0, n),
+			rule, upstreamResponse)); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// recordCacheWriteFailure records the reason the cache entry couldn't be written.
+func (server *Server) recordCacheWriteFailure(key string, err error) {
+	operationType := "write-failed"
+
+	switch {
+	case errors.Is(err, cachepkg.ErrTooLarge):
+		operationType = "too-large"
+
+		server.logger.Debugf("not creating a cache entry for key %q: %v", key, err)
+	case errors.Is(err, digest.ErrMismatch):
+		operationType = "digest-mismatch"
+
+		server.logger.Warnf("not creating a cache entry for key %q, the upstream "+
+			"has sent unexpected contents: %v", key, err)
+	default:
+		server.logger.Warnf("failed to create a cache entry for key %q, continuing "+
+			"without caching: %v", key, err)
+	}
+
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", operationType),
+	))
+}
+
+// recordMissSpeed records the speed at which the contents were fetched from the upstream.
+func (server *Server) recordMissSpeed(n int64, copyStartAt time.Time) {
+	bytesPerSecond := float64(n) / max(time.Since(copyStartAt).Seconds(), 1)
+
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheSpeedHistogram.Record(context.Background(), int64(bytesPerSecond), metric.WithAttributes(
+		attribute.String("type", "miss"),
+	))
+}
+
+// verifyingReader wraps the reader to verify the rule's expected digest
+// and the upstream's Docker-Content-Digest, when the rule asks for it.
+func (server *Server) verifyingReader(
+	key string,
+	reader io.Reader,
+	rule *rulepkg.Rule,
+	upstreamResponse *http.Response,
+) io.Reader {
+	if rule.VerifyDigest() == "" {
+		return reader
+	}
+
+	var expectedDigests []string
+
+	if expectedDigest, ok := rule.ExpectedDigest(originalURL(upstreamResponse)); ok {
+		expectedDigests = append(expectedDigests, expectedDigest)
+	}
+
+	if dockerContentDigest := upstreamResponse.Header.Get("Docker-Content-Digest"); dockerContentDigest != "" {
+		expectedDigests = append(expectedDigests, dockerContentDigest)
+	}
+
+	for _, expectedDigest := range expectedDigests {
+		digestReader, err := digest.NewReader(reader, expectedDigest)
+		if err != nil {
+			server.logger.Warnf("not verifying the digest of a cache entry for key %q: %v", key, err)
+
+			continue
+		}
+
+		reader = digestReader
+	}
+
+	return reader
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// maxRedirects is the maximum number of upstream's redirects followed
+// for a single request, which matches the net/http's default policy.
+const maxRedirects = 10
+
+type followRedirectsKey struct{}
+
+// withFollowRedirects marks the upstream request's context
+// as allowed to follow the upstream's redirects.
+func withFollowRedirects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, followRedirectsKey{}, true)
+}
+
+// checkRedirect is the upstream HTTP clients' redirect policy: the upstream's redirects are
+// passed through to the client, unless the matching rule asks to follow them, in which case
+// the final response is treated as if it was served by the original URL.
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if followRedirects, _ := request.Context().Value(followRedirectsKey{}).(bool); !followRedirects {
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	return nil
+}
+
+// originalURL returns the URL of the request that was originally sent to the
+// upstream, which differs from the upstream response's URL after redirects,
+// or the origin's URL when the response was served by a mirror.
+func originalURL(upstreamResponse *http.Response) string {
+	request := upstreamResponse.Request
+
+	if mirroredURL, ok := request.Context().Value(mirroredURLKey{}).(string); ok {
+		return mirroredURL
+	}
+
+	for request.Response != nil && request.Response.Request != nil {
+		request = request.Response.Request
+	}
+
+	return request.URL.String()
+}
@@ -0,0 +1,120 @@
+package registrymirror
+
+import (
+	"fmt"
+	"github.com/puzpuzpuz/xsync/v4"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathRe matches the OCI Distribution Specification's[1] pull endpoints,
+// capturing the repository name.
+//
+// [1]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pulling-manifests
+var pathRe = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/[^/]+$`)
+
+// RegistryMirror is a pull-through registry mirror endpoint
+// that serves the pull requests from the upstream registry.
+type RegistryMirror struct {
+	addr     string
+	upstream string
+	username string
+	password string
+
+	// tokens are the bearer tokens obtained from the upstream
+	// registry's authorization service, keyed by the scope
+	tokens *xsync.Map[string, token]
+}
+
+type Option func(registryMirror *RegistryMirror)
+
+// WithCredentials configures the credentials that are used
+// to obtain the bearer tokens for the upstream registry.
+func WithCredentials(username string, password string) Option {
+	return func(registryMirror *RegistryMirror) {
+		registryMirror.username = username
+		registryMirror.password = password
+	}
+}
+
+func New(addr string, upstream string, opts ...Option) (*RegistryMirror, error) {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse upstream registry URL %q: %w", upstream, err)
+	}
+
+	if (upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https") || upstreamURL.Host == "" ||
+		upstreamURL.RawQuery != "" || upstreamURL.Fragment != "" {
+		return nil, fmt.Errorf("upstream registry URL %q should be an HTTP or HTTPS base URL "+
+			"without a query or a fragment", upstream)
+	}
+
+	registryMirror := &RegistryMirror{
+		addr:     addr,
+		upstream: strings.TrimSuffix(upstream, "/"),
+		tokens:   xsync.NewMap[string, token](),
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(registryMirror)
+	}
+
+	return registryMirror, nil
+}
+
+func (registryMirror *RegistryMirror) Addr() string {
+	return registryMirror.addr
+}
+
+// Upstream returns the base URL of the upstream registry.
+func (registryMirror *RegistryMirror) Upstream() string {
+	return registryMirror.upstream
+}
+
+// UpstreamURL returns the upstream registry's URL for the given pull request, with the
+// second return value being false when the request is not a manifest or a blob pull.
+func (registryMirror *RegistryMirror) UpstreamURL(request *http.Request) (*url.URL, bool) {
+	if !pathRe.MatchString(request.URL.Path) {
+		return nil, false
+	}
+
+	rawUpstreamURL := registryMirror.upstream + request.URL.EscapedPath()
+
+	if request.URL.RawQuery != "" {
+		rawUpstreamURL += "?" + request.URL.RawQuery
+	}
+
+	upstreamURL, err := url.Parse(rawUpstreamURL)
+	if err != nil {
+		return nil, false
+	}
+
+	return upstreamURL, true
+}
+
+// scope returns the authorization scope needed to pull from the repository that the given
+// URL refers to, with the second return value being false when the URL doesn't belong to
+// the upstream registry (e.g. the blob storage that the upstream registry redirects to).
+func (registryMirror *RegistryMirror) scope(rawURL string) (string, bool) {
+	path, ok := strings.CutPrefix(rawURL, registryMirror.upstream)
+	if !ok || !strings.HasPrefix(path, "/") {
+		return "", false
+	}
+
+	path, _, _ = strings.Cut(path, "?")
+
+	matches := pathRe.FindStringSubmatch(path)
+	if matches == nil {
+		return "", true
+	}
+
+	name, err := url.PathUnescape(matches[1])
+	if err != nil {
+		return "", true
+	}
+
+	return fmt.Sprintf("repository:%s:pull", name), true
+}
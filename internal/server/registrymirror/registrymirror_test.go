@@ -0,0 +1,95 @@
+package registrymirror_test
+
+import (
+	"encoding/json"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpstreamURL(t *testing.T) {
+	registryMirror, err := registrymirror.New("127.0.0.1:5000", "https://registry.example.com/prefix/")
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest?ns=docker.io", nil)
+
+	upstreamURL, ok := registryMirror.UpstreamURL(request)
+	require.True(t, ok)
+	require.Equal(t, "https://registry.example.com/prefix/v2/library/alpine/manifests/latest?ns=docker.io",
+		upstreamURL.String())
+
+	// Only pulls are mapped
+	_, ok = registryMirror.UpstreamURL(httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil))
+	require.False(t, ok)
+
+	_, err = registrymirror.New("127.0.0.1:5000", "registry.example.com")
+	require.Error(t, err)
+}
+
+func TestTransport(t *testing.T) {
+	var tokenRequests atomic.Int64
+
+	registry := httptest.NewUnstartedServer(nil)
+
+	registry.Config.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/token":
+			tokenRequests.Add(1)
+
+			username, password, ok := request.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "user", username)
+			require.Equal(t, "pass", password)
+			require.Equal(t, "registry.test", request.URL.Query().Get("service"))
+			require.Equal(t, "repository:org/image:pull", request.URL.Query().Get("scope"))
+
+			_ = json.NewEncoder(writer).Encode(map[string]any{
+				"token":      "secret-token",
+				"expires_in": 300,
+			})
+		case "/registry/v2/org/image/manifests/latest":
+			if request.Header.Get("Authorization") != "Bearer secret-token" {
+				writer.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.URL+`/token",`+
+					`service="registry.test",scope="repository:org/image:pull"`)
+				writer.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			_, _ = writer.Write([]byte("manifest"))
+		default:
+			// Requests outside the upstream registry's base URL are never authenticated
+			require.Empty(t, request.Header.Get("Authorization"))
+
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	})
+	registry.Start()
+	t.Cleanup(registry.Close)
+
+	registryMirror, err := registrymirror.New("127.0.0.1:5000", registry.URL+"/registry",
+		registrymirror.WithCredentials("user", "pass"))
+	require.NoError(t, err)
+
+	httpClient := &http.Client{
+		Transport: registryMirror.Transport(http.DefaultTransport),
+	}
+
+	// The token is obtained once and then reused
+	for range 2 {
+		resp, err := httpClient.Get(registry.URL + "/registry/v2/org/image/manifests/latest")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.EqualValues(t, 1, tokenRequests.Load())
+
+	resp, err := httpClient.Get(registry.URL + "/storage/blob")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+}
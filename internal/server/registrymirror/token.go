@@ -0,0 +1,246 @@
+package registrymirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultTokenLifetime is the lifetime of the tokens that don't specify one,
+// as per the Docker Registry's "Token Authentication Specification"[1].
+//
+// [1]: https://distribution.github.io/distribution/spec/auth/token/
+const defaultTokenLifetime = 60 * time.Second
+
+// maxTokenResponseSize limits the authorization service's response that we're willing to parse.
+const maxTokenResponseSize = 1024 * 1024
+
+type token struct {
+	value     string
+	expiresAt time.Time
+}
+
+type bearerChallenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+type transport struct {
+	registryMirror *RegistryMirror
+	base           http.RoundTripper
+}
+
+// Transport returns an HTTP transport that authenticates the requests to the upstream
+// registry using the bearer token flow described in the Docker Registry's "Token
+// Authentication Specification"[1], obtaining and caching the tokens as needed.
+//
+// Requests to other hosts (e.g. the blob storage that the upstream
+// registry redirects to) are passed through as is.
+//
+// [1]: https://distribution.github.io/distribution/spec/auth/token/
+func (registryMirror *RegistryMirror) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{
+		registryMirror: registryMirror,
+		base:           base,
+	}
+}
+
+func (transport *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	scope, ok := transport.registryMirror.scope(request.URL.String())
+	if !ok {
+		return transport.base.RoundTrip(request)
+	}
+
+	// Use the previously obtained token, if any
+	if cachedToken, ok := transport.registryMirror.tokens.Load(scope); ok && time.Now().Before(cachedToken.expiresAt) {
+		request = withToken(request, cachedToken.value)
+	}
+
+	response, err := transport.base.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	// Requests with a body can't be replayed
+	if request.Body != nil && request.Body != http.NoBody {
+		return response, nil
+	}
+
+	challenge, ok := parseBearerChallenge(response.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return response, nil
+	}
+
+	_ = response.Body.Close()
+
+	newToken, err := transport.registryMirror.fetchToken(request, transport.base, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain a token from the upstream registry's "+
+			"authorization service: %w", err)
+	}
+
+	transport.registryMirror.tokens.Store(scope, newToken)
+
+	return transport.base.RoundTrip(withToken(request, newToken.value))
+}
+
+// fetchToken obtains a token from the authorization service specified in the challenge.
+func (registryMirror *RegistryMirror) fetchToken(
+	request *http.Request,
+	base http.RoundTripper,
+	challenge bearerChallenge,
+) (token, error) {
+	realmURL, err := url.Parse(challenge.realm)
+	if err != nil {
+		return token{}, fmt.Errorf("failed to parse realm %q: %w", challenge.realm, err)
+	}
+
+	if realmURL.Scheme != "https" && (realmURL.Scheme != "http" || request.URL.Scheme != "http") {
+		return token{}, fmt.Errorf("realm %q should use HTTPS scheme", challenge.realm)
+	}
+
+	query := realmURL.Query()
+
+	if challenge.service != "" {
+		query.Set("service", challenge.service)
+	}
+
+	if challenge.scope != "" {
+		query.Set("scope", challenge.scope)
+	}
+
+	realmURL.RawQuery = query.Encode()
+
+	tokenRequest, err := http.NewRequestWithContext(request.Context(), http.MethodGet, realmURL.String(), nil)
+	if err != nil {
+		return token{}, err
+	}
+
+	if registryMirror.username != "" {
+		tokenRequest.SetBasicAuth(registryMirror.username, registryMirror.password)
+	}
+
+	tokenResponse, err := base.RoundTrip(tokenRequest)
+	if err != nil {
+		return token{}, err
+	}
+	defer tokenResponse.Body.Close()
+
+	if tokenResponse.StatusCode != http.StatusOK {
+		return token{}, fmt.Errorf("authorization service responded with HTTP %d",
+			tokenResponse.StatusCode)
+	}
+
+	var tokenResponseJSON struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(tokenResponse.Body, maxTokenResponseSize)).
+		Decode(&tokenResponseJSON); err != nil {
+		return token{}, fmt.Errorf("failed to parse authorization service's response: %w", err)
+	}
+
+	// Both fields are allowed, with the "token" taking precedence
+	value := tokenResponseJSON.Token
+	if value == "" {
+		value = tokenResponseJSON.AccessToken
+	}
+
+	if value == "" {
+		return token{}, errors.New("authorization service responded without a token")
+	}
+
+	lifetime := defaultTokenLifetime
+
+	if tokenResponseJSON.ExpiresIn > 0 {
+		lifetime = time.Duration(tokenResponseJSON.ExpiresIn) * time.Second
+	}
+
+	return token{
+		value:     value,
+		expiresAt: time.Now().Add(lifetime),
+	}, nil
+}
+
+// withToken returns a copy of the request authenticated with the given token,
+// since the http.RoundTripper is not allowed to modify the request.
+func withToken(request *http.Request, value string) *http.Request {
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "Bearer "+value)
+
+	return request
+}
+
+// parseBearerChallenge parses the WWW-Authenticate header's Bearer challenge
+// (e.g. `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`),
+// with the second return value being false when there's no such challenge.
+func parseBearerChallenge(value string) (bearerChallenge, bool) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return bearerChallenge{}, false
+	}
+
+	var challenge bearerChallenge
+
+	for params != "" {
+		var key, paramValue string
+
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		// Parameter values are either quoted strings, which can
+		// contain commas (e.g. multiple actions in the scope),
+		// or tokens, which end with a comma
+		if strings.HasPrefix(params, `"`) {
+			paramValue, params = parseQuotedString(params[1:])
+		} else {
+			paramValue, params, _ = strings.Cut(params, ",")
+			paramValue = strings.TrimSpace(paramValue)
+		}
+
+		switch key {
+		case "realm":
+			challenge.realm = paramValue
+		case "service":
+			challenge.service = paramValue
+		case "scope":
+			challenge.scope = paramValue
+		}
+	}
+
+	if challenge.realm == "" {
+		return bearerChallenge{}, false
+	}
+
+	return challenge, true
+}
+
+// parseQuotedString parses the quoted string whose opening quote is already consumed,
+// returning its unescaped value and the remainder after the closing quote.
+func parseQuotedString(value string) (string, string) {
+	var result strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				result.WriteByte(value[i])
+			}
+		case '"':
+			return result.String(), value[i+1:]
+		default:
+			result.WriteByte(value[i])
+		}
+	}
+
+	return result.String(), ""
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// resumingBody is the upstream response's body that transparently resumes the download
+// using a range request when the connection to the upstream breaks in the middle,
+// so that neither the cache entry nor the client notice the interruption.
+type resumingBody struct {
+	server       *Server
+	httpClient   *http.Client
+	request      *http.Request
+	body         io.ReadCloser
+	validator    string
+	offset       int64
+	attemptsLeft uint
+}
+
+// newResumingBody wraps the upstream response's body to be resumed up to the rule's number
+// of attempts, if the upstream supports range requests and provides a validator for If-Range.
+func (server *Server) newResumingBody(upstreamResponse *http.Response, rule *rulepkg.Rule) io.ReadCloser {
+	attempts := rule.ResumeAttempts()
+
+	if attempts == 0 || upstreamResponse.Header.Get("Accept-Ranges") != "bytes" {
+		return upstreamResponse.Body
+	}
+
+	validator, ok := ifRangeValidator(upstreamResponse.Header)
+	if !ok {
+		return upstreamResponse.Body
+	}
+
+	return &resumingBody{
+		server:       server,
+		httpClient:   server.httpClient(upstreamResponse.Request, rule),
+		request:      upstreamResponse.Request,
+		body:         upstreamResponse.Body,
+		validator:    validator,
+		attemptsLeft: attempts,
+	}
+}
+
+func (body *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := body.body.Read(p)
+		body.offset += int64(n)
+
+		if err == nil || errors.Is(err, io.EOF) || body.attemptsLeft == 0 || body.request.Context().Err() != nil {
+			return n, err
+		}
+
+		if resumeErr := body.resume(); resumeErr != nil {
+			body.server.logger.Warnf("failed to resume the download of %s at offset %d: %v",
+				body.request.URL, body.offset, resumeErr)
+
+			return n, err
+		}
+
+		body.server.logger.Debugf("resumed the download of %s at offset %d after an error: %v",
+			body.request.URL, body.offset, err)
+
+		if n != 0 {
+			return n, nil
+		}
+	}
+}
+
+func (body *resumingBody) Close() error {
+	return body.body.Close()
+}
+
+func (body *resumingBody) resume() error {
+	body.attemptsLeft--
+
+	_ = body.body.Close()
+
+	request := body.request.Clone(body.request.Context())
+
+	// Our own revalidation preconditions are irrelevant when resuming
+	request.Header.Del("If-None-Match")
+	request.Header.Del("If-Modified-Since")
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-", body.offset))
+	request.Header.Set("If-Range", body.validator)
+
+	response, err := body.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusPartialContent {
+		_ = response.Body.Close()
+
+		return fmt.Errorf("upstream responded with HTTP %d instead of HTTP %d",
+			response.StatusCode, http.StatusPartialContent)
+	}
+
+	if start, ok := contentRangeStart(response.Header.Get("Content-Range")); !ok || start != body.offset {
+		_ = response.Body.Close()
+
+		return fmt.Errorf("upstream responded with an unexpected Content-Range %q",
+			response.Header.Get("Content-Range"))
+	}
+
+	body.body = response.Body
+
+	return nil
+}
+
+// ifRangeValidator returns the upstream's validator suitable for the If-Range header field,
+// which requires a strong validator, see RFC 9110 "HTTP Semantics", §13.1.5 "If-Range"[1],
+// otherwise we can't guarantee that the ranges belong to the same representation.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.1.5
+func ifRangeValidator(header http.Header) (string, bool) {
+	if eTag := header.Get("ETag"); eTag != "" && !strings.HasPrefix(eTag, "W/") {
+		return eTag, true
+	}
+
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		return lastModified, true
+	}
+
+	return "", false
+}
+
+// contentRangeStart returns the first byte position of the Content-Range
+// header field value (e.g. "bytes 100-199/200"), if it can be parsed.
+func contentRangeStart(contentRange string) (int64, bool) {
+	rangeAndSize, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+
+	rawStart, _, ok := strings.Cut(rangeAndSize, "-")
+	if !ok {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(rawStart, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return start, true
+}
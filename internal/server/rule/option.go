@@ -0,0 +1,80 @@
+package rule
+
+import (
+	"github.com/cirruslabs/chacha/internal/server/upstream"
+	"time"
+)
+
+type Option func(rule *Rule)
+
+func WithFreshFor(freshFor time.Duration) Option {
+	return func(rule *Rule) {
+		rule.freshFor = freshFor
+	}
+}
+
+func WithStaleIfError(staleIfError time.Duration) Option {
+	return func(rule *Rule) {
+		rule.staleIfError = staleIfError
+	}
+}
+
+func WithBackgroundFill(backgroundFill bool) Option {
+	return func(rule *Rule) {
+		rule.backgroundFill = &backgroundFill
+	}
+}
+
+func WithMaxSize(maxSize uint64) Option {
+	return func(rule *Rule) {
+		rule.maxSize = maxSize
+	}
+}
+
+func WithVerifyDigest(verifyDigest string) Option {
+	return func(rule *Rule) {
+		rule.verifyDigest = verifyDigest
+	}
+}
+
+func WithCacheKey(cacheKey string) Option {
+	return func(rule *Rule) {
+		rule.cacheKey = cacheKey
+	}
+}
+
+func WithFollowRedirects(followRedirects bool) Option {
+	return func(rule *Rule) {
+		rule.followRedirects = followRedirects
+	}
+}
+
+func WithNegativeTTL(negativeTTL time.Duration) Option {
+	return func(rule *Rule) {
+		rule.negativeTTL = negativeTTL
+	}
+}
+
+func WithResumeAttempts(resumeAttempts uint) Option {
+	return func(rule *Rule) {
+		rule.resumeAttempts = resumeAttempts
+	}
+}
+
+func WithParallelFetch(parallelFetch uint) Option {
+	return func(rule *Rule) {
+		rule.parallelFetch = parallelFetch
+	}
+}
+
+func WithUpstream(upstream upstream.Settings) Option {
+	return func(rule *Rule) {
+		rule.upstream = upstream
+	}
+}
+
+func WithMirrors(mirrors []string) Option {
+	return func(rule *Rule) {
+		rule.mirrors = mirrors
+	}
+}
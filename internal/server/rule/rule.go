@@ -2,9 +2,21 @@ package rule
 
 import (
 	"fmt"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// VerifyDigestFromPath is the verify-digest value that takes
+// the SHA-256 digest from the URL's path (e.g. "/v2/<name>/blobs/sha256:<hex>").
+const VerifyDigestFromPath = "sha256-from-path"
+
+var sha256DigestRe = regexp.MustCompile(`sha256:[0-9a-fA-F]{64}`)
+
 type Rules []Rule
 
 type Rule struct {
@@ -13,6 +25,18 @@ type Rule struct {
 	ignoreParameters          []string
 	directConnect             bool
 	directConnectHeader       bool
+	freshFor                  time.Duration
+	staleIfError              time.Duration
+	backgroundFill            *bool
+	maxSize                   uint64
+	verifyDigest              string
+	cacheKey                  string
+	followRedirects           bool
+	negativeTTL               time.Duration
+	resumeAttempts            uint
+	parallelFetch             uint
+	upstream                  upstream.Settings
+	mirrors                   []string
 }
 
 func New(
@@ -21,6 +45,7 @@ func New(
 	ignoreParameters []string,
 	directConnect bool,
 	directConnectHeader bool,
+	opts ...Option,
 ) (Rule, error) {
 	re, err := regexp.Compile(pattern)
 	if err != nil {
@@ -28,13 +53,58 @@ func New(
 			pattern, err)
 	}
 
-	return Rule{
+	rule := Rule{
 		re:                        re,
 		ignoreAuthorizationHeader: ignoreAuthorizationHeader,
 		ignoreParameters:          ignoreParameters,
 		directConnect:             directConnect,
 		directConnectHeader:       directConnectHeader,
-	}, nil
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(&rule)
+	}
+
+	if err := rule.upstream.Validate(); err != nil {
+		return Rule{}, fmt.Errorf("invalid upstream settings for path pattern %s: %w", pattern, err)
+	}
+
+	for _, mirror := range rule.mirrors {
+		mirrorURL, err := url.Parse(mirror)
+		if err != nil {
+			return Rule{}, fmt.Errorf("failed to parse mirror URL %q for path pattern %s: %w",
+				mirror, pattern, err)
+		}
+
+		if (mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https") || mirrorURL.Host == "" ||
+			mirrorURL.RawQuery != "" || mirrorURL.Fragment != "" {
+			return Rule{}, fmt.Errorf("mirror URL %q for path pattern %s should be an HTTP or HTTPS "+
+				"base URL without a query or a fragment", mirror, pattern)
+		}
+	}
+
+	if rule.verifyDigest != "" && rule.verifyDigest != VerifyDigestFromPath &&
+		re.SubexpIndex(rule.verifyDigest) == -1 {
+		return Rule{}, fmt.Errorf("digest verification for path pattern %s should either be %q or refer "+
+			"to a named capture group, got %q", pattern, VerifyDigestFromPath, rule.verifyDigest)
+	}
+
+	for _, group := range cacheKeyGroups(rule.cacheKey) {
+		if rule.groupIndex(group) == -1 {
+			return Rule{}, fmt.Errorf("cache key template for path pattern %s refers to a non-existent "+
+				"capture group %q", pattern, group)
+		}
+	}
+
+	// The cache entries shared between different URLs should never be served
+	// without contacting the upstream, as this would skip its authorization check
+	if rule.cacheKey != "" && rule.freshFor != 0 {
+		return Rule{}, fmt.Errorf("freshness lifetime for path pattern %s can't be used together "+
+			"with the cache key template", pattern)
+	}
+
+	return rule, nil
 }
 
 func (rule Rule) IgnoreAuthorizationHeader() bool {
@@ -53,6 +123,225 @@ func (rule Rule) DirectConnectHeader() bool {
 	return rule.directConnectHeader
 }
 
+func (rule Rule) FreshFor() time.Duration {
+	return rule.freshFor
+}
+
+func (rule Rule) StaleIfError() time.Duration {
+	return rule.staleIfError
+}
+
+// BackgroundFill returns whether the fill should continue in the background when
+// the client goes away, with the second return value being false when not set.
+func (rule Rule) BackgroundFill() (bool, bool) {
+	if rule.backgroundFill == nil {
+		return false, false
+	}
+
+	return *rule.backgroundFill, true
+}
+
+// MaxSize returns the maximum size of the cache entries, with zero meaning no limit.
+func (rule Rule) MaxSize() uint64 {
+	return rule.maxSize
+}
+
+// VerifyDigest returns the digest verification setting, with an empty string
+// meaning that the digests are not verified.
+func (rule Rule) VerifyDigest() string {
+	return rule.verifyDigest
+}
+
+// ExpectedDigest returns the digest (e.g. "sha256:<hex>") that the contents of the given
+// URL are expected to have, with the second return value being false when not known.
+func (rule Rule) ExpectedDigest(rawURL string) (string, bool) {
+	switch rule.verifyDigest {
+	case "":
+		return "", false
+	case VerifyDigestFromPath:
+		parsedURL, err := url.Parse(rawURL)
+		if err != nil {
+			return "", false
+		}
+
+		matches := sha256DigestRe.FindAllString(parsedURL.Path, -1)
+		if len(matches) == 0 {
+			return "", false
+		}
+
+		return strings.ToLower(matches[len(matches)-1]), true
+	default:
+		submatches := rule.re.FindStringSubmatch(rawURL)
+		if submatches == nil {
+			return "", false
+		}
+
+		digest := submatches[rule.re.SubexpIndex(rule.verifyDigest)]
+		if digest == "" {
+			return "", false
+		}
+
+		// Bare digests are assumed to be SHA-256
+		if !strings.Contains(digest, ":") {
+			digest = "sha256:" + digest
+		}
+
+		return digest, true
+	}
+}
+
+// CacheKey returns the cache key template, with an empty
+// string meaning that the cache key is derived from the URL.
+func (rule Rule) CacheKey() string {
+	return rule.cacheKey
+}
+
+// FollowRedirects returns whether the upstream's redirects should be followed,
+// with the final response being cached under the original request's cache key.
+func (rule Rule) FollowRedirects() bool {
+	return rule.followRedirects
+}
+
+// NegativeTTL returns for how long the upstream's negative responses (404 and 410)
+// are served from the cache, with zero meaning that they're not cached.
+func (rule Rule) NegativeTTL() time.Duration {
+	return rule.negativeTTL
+}
+
+// ResumeAttempts returns how many times an interrupted upstream download can be
+// resumed using a range request, with zero meaning that it's never resumed.
+func (rule Rule) ResumeAttempts() uint {
+	return rule.resumeAttempts
+}
+
+// ParallelFetch returns the number of concurrent range requests used to fetch
+// the upstream's response, with zero or one meaning a single request.
+func (rule Rule) ParallelFetch() uint {
+	return rule.parallelFetch
+}
+
+// Upstream returns the upstream settings that override the global ones,
+// with zero values meaning that the global settings are used.
+func (rule Rule) Upstream() upstream.Settings {
+	return rule.upstream
+}
+
+// MirrorURLs returns the URLs that the given URL is available at on the rule's mirrors, which are
+// tried in order before contacting the origin, with the mirror's base URL (e.g.
+// "https://mirror.example.com/docker-hub") prefixing the path.
+func (rule Rule) MirrorURLs(rawURL string) []string {
+	if len(rule.mirrors) == 0 {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	mirrorURLs := make([]string, 0, len(rule.mirrors))
+
+	for _, mirror := range rule.mirrors {
+		mirrorURL := strings.TrimSuffix(mirror, "/") + parsedURL.EscapedPath()
+
+		if parsedURL.RawQuery != "" {
+			mirrorURL += "?" + parsedURL.RawQuery
+		}
+
+		mirrorURLs = append(mirrorURLs, mirrorURL)
+	}
+
+	return mirrorURLs
+}
+
+// ExpandCacheKey returns the cache key for the given URL by expanding the rule's cache key template
+// (e.g. "oci-blob:${digest}") with the pattern's capture groups, with the second return value
+// being false when the template is not set, any of the capture groups it refers to didn't match
+// or matched an empty string, or the template expands to an empty string.
+func (rule Rule) ExpandCacheKey(rawURL string) (string, bool) {
+	if rule.cacheKey == "" {
+		return "", false
+	}
+
+	submatches := rule.re.FindStringSubmatchIndex(rawURL)
+	if submatches == nil {
+		return "", false
+	}
+
+	for _, group := range cacheKeyGroups(rule.cacheKey) {
+		i := rule.groupIndex(group)
+
+		if i == -1 || submatches[2*i] == -1 || submatches[2*i] == submatches[2*i+1] {
+			return "", false
+		}
+	}
+
+	cacheKey := string(rule.re.ExpandString(nil, rule.cacheKey, rawURL, submatches))
+	if cacheKey == "" {
+		return "", false
+	}
+
+	return cacheKey, true
+}
+
+// groupIndex returns the index of the pattern's capture group referred to
+// by its name or its number, or -1 if there's no such capture group.
+func (rule Rule) groupIndex(group string) int {
+	if i, err := strconv.Atoi(group); err == nil {
+		if i < 0 || i > rule.re.NumSubexp() {
+			return -1
+		}
+
+		return i
+	}
+
+	return rule.re.SubexpIndex(group)
+}
+
+// cacheKeyGroups returns the capture groups that the cache key template refers to
+// using the "$name" and "${name}" syntax of the regexp.Regexp's Expand[1].
+//
+// [1]: https://pkg.go.dev/regexp#Regexp.Expand
+func cacheKeyGroups(template string) []string {
+	var groups []string
+
+	for {
+		i := strings.IndexByte(template, '$')
+		if i == -1 {
+			return groups
+		}
+
+		template = template[i+1:]
+
+		// "$$" is a literal "$"
+		if strings.HasPrefix(template, "$") {
+			template = template[1:]
+
+			continue
+		}
+
+		braced := strings.HasPrefix(template, "{")
+		if braced {
+			template = template[1:]
+		}
+
+		end := strings.IndexFunc(template, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end == -1 {
+			end = len(template)
+		}
+
+		// Malformed references are expanded as is
+		if end == 0 || (braced && !strings.HasPrefix(template[end:], "}")) {
+			continue
+		}
+
+		groups = append(groups, template[:end])
+		template = template[end:]
+	}
+}
+
 func (rules Rules) Get(url string) *Rule {
 	for _, rule := range rules {
 		if rule.re.MatchString(url) {
@@ -4,6 +4,7 @@ import (
 	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestNewLineIsCounteredByUsingBeginningAndEnd(t *testing.T) {
@@ -35,3 +36,108 @@ func TestFirstMatchWins(t *testing.T) {
 	require.NotNil(t, rule)
 	require.Equal(t, []string{"X-Coarse"}, rule.IgnoredParameters())
 }
+
+func TestExpectedDigest(t *testing.T) {
+	const digest = "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+
+	fromPath, err := rulepkg.New(`https://ghcr.io/v2/.*`, false, nil, false, false,
+		rulepkg.WithVerifyDigest(rulepkg.VerifyDigestFromPath))
+	require.NoError(t, err)
+
+	actual, ok := fromPath.ExpectedDigest("https://ghcr.io/v2/org/repo/blobs/" + digest)
+	require.True(t, ok)
+	require.Equal(t, digest, actual)
+
+	_, ok = fromPath.ExpectedDigest("https://ghcr.io/v2/org/repo/manifests/latest")
+	require.False(t, ok)
+
+	fromGroup, err := rulepkg.New(`https://example.com/files/(?P<digest>[0-9a-f]{64})`, false, nil, false, false,
+		rulepkg.WithVerifyDigest("digest"))
+	require.NoError(t, err)
+
+	actual, ok = fromGroup.ExpectedDigest("https://example.com/files/" + digest[len("sha256:"):])
+	require.True(t, ok)
+	require.Equal(t, digest, actual)
+
+	_, err = rulepkg.New(`https://example.com/.*`, false, nil, false, false,
+		rulepkg.WithVerifyDigest("nonexistent"))
+	require.Error(t, err)
+}
+
+func TestCacheKey(t *testing.T) {
+	contentAddressed, err := rulepkg.New(`^https://[^/]+/v2/.+/blobs/(?P<digest>sha256:[0-9a-f]{64})$`,
+		false, nil, false, false, rulepkg.WithCacheKey("oci-blob:${digest}"))
+	require.NoError(t, err)
+
+	const digest = "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+
+	first, ok := contentAddressed.ExpandCacheKey("https://ghcr.io/v2/org/a/blobs/" + digest)
+	require.True(t, ok)
+	require.Equal(t, "oci-blob:"+digest, first)
+
+	second, ok := contentAddressed.ExpandCacheKey("https://mirror.gcr.io/v2/org/b/blobs/" + digest)
+	require.True(t, ok)
+	require.Equal(t, first, second)
+
+	withoutTemplate, err := rulepkg.New(`.*`, false, nil, false, false)
+	require.NoError(t, err)
+
+	_, ok = withoutTemplate.ExpandCacheKey("https://ghcr.io/v2/org/a/blobs/" + digest)
+	require.False(t, ok)
+}
+
+func TestCacheKeyGroups(t *testing.T) {
+	// Templates referring to the non-existent capture groups should be rejected
+	_, err := rulepkg.New(`^https://example\.com/(?P<name>[^/]+)$`, false, nil, false, false,
+		rulepkg.WithCacheKey("blob:${digest}"))
+	require.Error(t, err)
+
+	_, err = rulepkg.New(`^https://example\.com/(?P<name>[^/]+)$`, false, nil, false, false,
+		rulepkg.WithCacheKey("blob:$2"))
+	require.Error(t, err)
+
+	// Cache entries shared between different URLs should never be served without contacting the upstream
+	_, err = rulepkg.New(`^https://example\.com/(?P<name>[^/]+)$`, false, nil, false, false,
+		rulepkg.WithCacheKey("blob:${name}"), rulepkg.WithFreshFor(time.Minute))
+	require.Error(t, err)
+
+	// Templates should only expand when all the capture groups they refer to have matched
+	optional, err := rulepkg.New(`^https://example\.com/(?P<name>[^/]*)(?:/(?P<tag>[^/]+))?$`,
+		false, nil, false, false, rulepkg.WithCacheKey("blob:${name}:$tag:$$"))
+	require.NoError(t, err)
+
+	cacheKey, ok := optional.ExpandCacheKey("https://example.com/a/latest")
+	require.True(t, ok)
+	require.Equal(t, "blob:a:latest:$", cacheKey)
+
+	_, ok = optional.ExpandCacheKey("https://example.com/a")
+	require.False(t, ok)
+
+	_, ok = optional.ExpandCacheKey("https://example.com//latest")
+	require.False(t, ok)
+}
+
+func TestMirrorURLs(t *testing.T) {
+	mirrored, err := rulepkg.New(`^https://registry-1\.docker\.io/.*$`, false, nil, false, false,
+		rulepkg.WithMirrors([]string{
+			"https://mirror.example.com/docker-hub/",
+			"http://10.0.0.1:5000",
+		}))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"https://mirror.example.com/docker-hub/v2/library/alpine/blobs/sha256:abc?ns=docker.io",
+		"http://10.0.0.1:5000/v2/library/alpine/blobs/sha256:abc?ns=docker.io",
+	}, mirrored.MirrorURLs("https://registry-1.docker.io/v2/library/alpine/blobs/sha256:abc?ns=docker.io"))
+
+	withoutMirrors, err := rulepkg.New(`.*`, false, nil, false, false)
+	require.NoError(t, err)
+	require.Empty(t, withoutMirrors.MirrorURLs("https://registry-1.docker.io/v2/"))
+
+	_, err = rulepkg.New(`.*`, false, nil, false, false, rulepkg.WithMirrors([]string{"ftp://mirror.example.com"}))
+	require.Error(t, err)
+
+	_, err = rulepkg.New(`.*`, false, nil, false, false,
+		rulepkg.WithMirrors([]string{"https://mirror.example.com/?token=secret"}))
+	require.Error(t, err)
+}
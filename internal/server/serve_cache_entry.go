@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	"github.com/cirruslabs/chacha/internal/server/httprange"
+	"github.com/cirruslabs/chacha/internal/server/responder"
+	rulepkg "github.com/cirruslabs/chacha/internal/server/rule"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// respondWithCacheEntry responds to the client using a cache entry that is known
+// to be usable, either by redirecting the client to the Chacha server holding
+// this cache entry (when direct connect is enabled) or by serving it directly.
+func (server *Server) respondWithCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	rule *rulepkg.Rule,
+	key string,
+	cacheEntryReader io.ReadSeeker,
+	cacheEntrySize int64,
+	metadata cachepkg.Metadata,
+	hitType string,
+) responder.Responder {
+	// The client already has an up-to-date representation
+	if isNotModified(request, metadata) {
+		//nolint:contextcheck // can's use request.Context() here because it might be canceled
+		server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("type", hitType),
+		))
+
+		return responder.NewCodef(http.StatusNotModified, "client's representation is up-to-date (%s)",
+			hitType)
+	}
+
+	// Perform redirection to a Chacha server holding
+	// this cache entry when direct connect is enabled
+	if server.cluster != nil && rule != nil && rule.DirectConnect() && request.Method != http.MethodHead &&
+		metadata.StatusCode == 0 {
+		// Variants are always stored on the same node as their primary key
+		primaryKey, _ := cachepkg.SplitKey(key)
+
+		directConnectURL := url.URL{
+			Scheme: "http",
+			Host:   server.cluster.TargetNode(primaryKey),
+			Path:   "/direct-connect",
+		}
+
+		directConnectToken, err := server.generateDirectConnectToken(key)
+		if err != nil {
+			return responder.NewCodef(http.StatusInternalServerError,
+				"failed to generate direct connect token: %v", err)
+		}
+
+		query := directConnectURL.Query()
+		query.Set("token", directConnectToken)
+		directConnectURL.RawQuery = query.Encode()
+
+		writer.Header().Set("Location", directConnectURL.String())
+
+		// Provide a direct connect hint so that the client
+		// can disable the proxy server for faster retrieval
+		if rule.DirectConnectHeader() {
+			writer.Header().Set("X-Chacha-Direct-Connect", "1")
+		}
+
+		return responder.NewCodef(http.StatusTemporaryRedirect, "redirected with a direct connect hint")
+	}
+
+	// Otherwise return the cache entry contents
+	copyStartAt := time.Now()
+
+	n, errResponder := server.serveCacheEntry(writer, request, cacheEntryReader, cacheEntrySize, metadata)
+	if errResponder != nil {
+		return errResponder
+	}
+
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheOperationCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", hitType),
+	))
+
+	if request.Method == http.MethodHead {
+		return responder.NewEmptyf("answered from the cache entry metadata (%s)", hitType)
+	}
+
+	bytesPerSecond := float64(n) / max(time.Since(copyStartAt).Seconds(), 1)
+
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.cacheSpeedHistogram.Record(context.Background(), int64(bytesPerSecond), metric.WithAttributes(
+		attribute.String("type", hitType),
+	))
+
+	return responder.NewEmptyf("retrieved from the cache (%s)", hitType)
+}
+
+// respondWithStaleCacheEntry responds to the client using a stale cache entry
+// when the upstream is not available, marking the response as such.
+func (server *Server) respondWithStaleCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	rule *rulepkg.Rule,
+	key string,
+	cacheEntryReader io.ReadSeeker,
+	cacheEntrySize int64,
+	metadata cachepkg.Metadata,
+) responder.Responder {
+	setCacheEntryHeaders(writer.Header(), metadata)
+
+	// RFC 5861 "HTTP Cache-Control Extensions for Stale Content", §4 "The stale-if-error
+	// Cache-Control Extension"[1] suggests to use the "111 Revalidation Failed" warning
+	//
+	// [1]: https://datatracker.ietf.org/doc/html/rfc5861#section-4
+	writer.Header().Set("Warning", `111 - "Revalidation Failed"`)
+	writer.Header().Set("X-Chacha-Stale", "1")
+
+	return server.respondWithCacheEntry(writer, request, rule, key, cacheEntryReader, cacheEntrySize,
+		metadata, "stale-hit")
+}
+
+// setCacheEntryHeaders provides the headers for the responses
+// that are produced from the cache entry alone.
+func setCacheEntryHeaders(header http.Header, metadata cachepkg.Metadata) {
+	for key, values := range metadata.Header {
+		header[key] = slices.Clone(values)
+	}
+
+	if metadata.ETag != "" {
+		header.Set("ETag", metadata.ETag)
+	}
+
+	if metadata.LastModified != "" {
+		header.Set("Last-Modified", metadata.LastModified)
+	}
+
+	header.Set("Age", strconv.FormatInt(int64(time.Since(metadata.FetchedAt).Seconds()), 10))
+}
+
+// updateCacheEntryHeaders combines the headers of the upstream's 304 response (already
+// present in the header) with the stored ones, as per RFC 9111 "HTTP Caching",
+// §4.3.4 "Freshening Stored Responses upon Validation"[1].
+//
+// Header fields from the 304 response take precedence over the stored ones.
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-4.3.4
+func updateCacheEntryHeaders(header http.Header, metadata cachepkg.Metadata) {
+	storedHeader := metadata.Header.Clone()
+	if storedHeader == nil {
+		storedHeader = http.Header{}
+	}
+
+	if metadata.ETag != "" {
+		storedHeader.Set("ETag", metadata.ETag)
+	}
+
+	if metadata.LastModified != "" {
+		storedHeader.Set("Last-Modified", metadata.LastModified)
+	}
+
+	for key, values := range storedHeader {
+		if _, ok := header[key]; ok {
+			continue
+		}
+
+		header[key] = values
+	}
+}
+
+// storableHeader returns the upstream's response headers that
+// are stored with the cache entry to be replayed later.
+//
+// Header fields that only make sense for a particular response (e.g. Date),
+// describe the message framing (e.g. Content-Length) or are produced by
+// Chacha itself are not stored, see RFC 9111 "HTTP Caching",
+// §3.1 "Storing Header and Trailer Fields"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9111#section-3.1
+func storableHeader(header http.Header) http.Header {
+	result := header.Clone()
+
+	removeEndToEndHeaders(result)
+
+	for _, key := range []string{
+		"Accept-Ranges",
+		"Age",
+		"Content-Length",
+		"Content-Range",
+		"Date",
+		"Set-Cookie",
+		"Warning",
+		// These are stored in the metadata separately
+		"ETag",
+		"Last-Modified",
+	} {
+		result.Del(key)
+	}
+
+	for key := range result {
+		if strings.HasPrefix(key, "X-Chacha-") {
+			delete(result, key)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// serveCacheEntry writes the cache entry contents to the client, honoring
+// the Range and If-Range headers, and returns the number of bytes written.
+//
+// The cache entry reader can be nil when serving HEAD requests.
+//
+// Responder is only returned in case of an error.
+func (server *Server) serveCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	cacheEntryReader io.ReadSeeker,
+	size int64,
+	metadata cachepkg.Metadata,
+) (int64, responder.Responder) {
+	// Negative cache entries are served as is, since range
+	// requests only apply to the successful responses
+	if metadata.StatusCode != 0 {
+		return server.serveNegativeCacheEntry(writer, request, cacheEntryReader, size, metadata)
+	}
+
+	var ranges []httprange.Range
+	var err error
+
+	// According to RFC 9110 "HTTP Semantics", §14.2 "Range",
+	// a server MUST ignore a Range header field received
+	// with a request method that is unrecognized or for
+	// which range handling is not defined.
+	//
+	// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-14.2
+	rangeHeader := request.Header.Get("Range")
+
+	if request.Method == http.MethodGet && rangeHeader != "" && ifRangeMatches(request, metadata) {
+		ranges, err = httprange.Parse(rangeHeader, size)
+		if err != nil {
+			if errors.Is(err, httprange.ErrNoOverlap) && size != 0 {
+				writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+				return 0, responder.NewCodef(http.StatusRequestedRangeNotSatisfiable,
+					"requested range %q is not satisfiable", rangeHeader)
+			}
+
+			// Range header is malformed, we're allowed
+			// to ignore it and serve the whole content
+			ranges = nil
+		}
+	}
+
+	n, err := httprange.Serve(writer, request, cacheEntryReader, size, ranges)
+	if err != nil {
+		return n, responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+			"to the client: %v", err)
+	}
+
+	return n, nil
+}
+
+// serveNegativeCacheEntry replays the upstream's negative response from the cache entry.
+func (server *Server) serveNegativeCacheEntry(
+	writer http.ResponseWriter,
+	request *http.Request,
+	cacheEntryReader io.ReadSeeker,
+	size int64,
+	metadata cachepkg.Metadata,
+) (int64, responder.Responder) {
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	writer.WriteHeader(metadata.StatusCode)
+
+	if request.Method == http.MethodHead {
+		return 0, nil
+	}
+
+	if _, err := cacheEntryReader.Seek(0, io.SeekStart); err != nil {
+		return 0, responder.NewCodef(http.StatusInternalServerError, "failed to seek "+
+			"the cache entry: %v", err)
+	}
+
+	n, err := io.Copy(writer, cacheEntryReader)
+	if err != nil {
+		return n, responder.NewCodef(http.StatusInternalServerError, "failed to write all data "+
+			"to the client: %v", err)
+	}
+
+	return n, nil
+}
+
+// ifRangeMatches evaluates the If-Range precondition against the cache entry
+// as per RFC 9110 "HTTP Semantics", §13.1.5 "If-Range"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-13.1.5
+func ifRangeMatches(request *http.Request, metadata cachepkg.Metadata) bool {
+	ifRange := request.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	// A valid entity-tag can be distinguished from a valid HTTP-date by
+	// examining the first three characters for a DQUOTE, with weak
+	// entity-tags never matching since a strong comparison is required
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return metadata.ETag != "" && !strings.HasPrefix(metadata.ETag, "W/") &&
+			ifRange == metadata.ETag
+	}
+
+	// Otherwise it's an HTTP-date, which needs to exactly match the Last-Modified
+	if metadata.LastModified == "" {
+		return false
+	}
+
+	ifRangeTime, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	lastModifiedTime, err := http.ParseTime(metadata.LastModified)
+	if err != nil {
+		return false
+	}
+
+	return ifRangeTime.Equal(lastModifiedTime)
+}
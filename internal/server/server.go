@@ -9,11 +9,15 @@ import (
 	"github.com/cirruslabs/chacha/internal/opentelemetry"
 	"github.com/cirruslabs/chacha/internal/server/capturingresponsewriter"
 	"github.com/cirruslabs/chacha/internal/server/cluster"
+	"github.com/cirruslabs/chacha/internal/server/fill"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
 	responderpkg "github.com/cirruslabs/chacha/internal/server/responder"
 	"github.com/cirruslabs/chacha/internal/server/rule"
 	"github.com/cirruslabs/chacha/internal/server/tlsinterceptor"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
 	"github.com/cirruslabs/chacha/pkg/localnetworkhelper"
 	"github.com/im7mortal/kmutex"
+	"github.com/puzpuzpuz/xsync/v4"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
@@ -30,8 +34,8 @@ type Server struct {
 	listener           net.Listener
 	httpServer         *http.Server
 	internalHTTPClient *http.Client
-	externalHTTPClient *http.Client
 	kmutex             *kmutex.Kmutex
+	fills              *xsync.Map[string, *fill.Fill]
 	logger             *zap.SugaredLogger
 
 	disk               cachepkg.Cache
@@ -39,6 +43,27 @@ type Server struct {
 	rules              rule.Rules
 	cluster            *cluster.Cluster
 	localNetworkHelper *localnetworkhelper.LocalNetworkHelper
+	backgroundFill     *backgroundFill
+	upstream           upstream.Settings
+	registryMirrors    []*registrymirror.RegistryMirror
+	bazel              *bazel
+	gradle             *gradle
+
+	// upstreamHTTPClients are the HTTP clients used to perform requests
+	// to the upstream, one for each distinct set of upstream settings
+	upstreamHTTPClients *xsync.Map[upstream.Settings, *http.Client]
+
+	// endpoints are the additional listeners served alongside the proxy,
+	// with each kind of endpoint also kept separately for lookup
+	endpoints               []*endpoint
+	registryMirrorEndpoints []*endpoint
+	bazelEndpoint           *endpoint
+	gradleEndpoint          *endpoint
+
+	// backgroundCtx is a server-owned context for the work
+	// that outlives the requests (e.g. background fills)
+	backgroundCtx    context.Context
+	backgroundCancel context.CancelFunc
 
 	// Metrics
 	requestsCounter       metric.Int64Counter
@@ -48,15 +73,14 @@ type Server struct {
 
 func New(addr string, opts ...Option) (*Server, error) {
 	server := &Server{
-		internalHTTPClient: http.DefaultClient,
-		externalHTTPClient: &http.Client{
-			Transport: &http.Transport{
-				DisableCompression: true,
-			},
-		},
-		kmutex: kmutex.New(),
+		internalHTTPClient:  http.DefaultClient,
+		kmutex:              kmutex.New(),
+		fills:               xsync.NewMap[string, *fill.Fill](),
+		upstreamHTTPClients: xsync.NewMap[upstream.Settings, *http.Client](),
 	}
 
+	server.backgroundCtx, server.backgroundCancel = context.WithCancel(context.Background())
+
 	// Listen on the desired port
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -76,6 +100,10 @@ func New(addr string, opts ...Option) (*Server, error) {
 		opt(server)
 	}
 
+	if err := server.upstream.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Apply defaults
 	if server.disk == nil {
 		server.disk = nooppkg.New()
@@ -108,6 +136,37 @@ func New(addr string, opts ...Option) (*Server, error) {
 		}
 	}
 
+	// Listen on the registry mirror endpoints' ports
+	for _, registryMirror := range server.registryMirrors {
+		registryMirrorEndpoint, err := server.newRegistryMirrorEndpoint(registryMirror)
+		if err != nil {
+			return nil, err
+		}
+
+		server.endpoints = append(server.endpoints, registryMirrorEndpoint)
+		server.registryMirrorEndpoints = append(server.registryMirrorEndpoints, registryMirrorEndpoint)
+	}
+
+	// Listen on the Bazel remote cache endpoint's port
+	if server.bazel != nil {
+		server.bazelEndpoint, err = server.newBazelEndpoint()
+		if err != nil {
+			return nil, err
+		}
+
+		server.endpoints = append(server.endpoints, server.bazelEndpoint)
+	}
+
+	// Listen on the Gradle build cache endpoint's port
+	if server.gradle != nil {
+		server.gradleEndpoint, err = server.newGradleEndpoint()
+		if err != nil {
+			return nil, err
+		}
+
+		server.endpoints = append(server.endpoints, server.gradleEndpoint)
+	}
+
 	// Use a customized internal HTTP client when "Local Network" permission helper is enabled
 	if server.localNetworkHelper != nil {
 		server.internalHTTPClient = &http.Client{
@@ -115,16 +174,6 @@ func New(addr string, opts ...Option) (*Server, error) {
 				DialContext: server.localNetworkHelper.PrivilegedDialContext,
 			}, otelhttp.WithMeterProvider(noop.NewMeterProvider())),
 		}
-
-		// We need this when using direct connect functionality
-		// with direct connect header disabled
-		server.externalHTTPClient = &http.Client{
-			Transport: &http.Transport{
-				DialContext: server.localNetworkHelper.PrivilegedDialContext,
-
-				DisableCompression: true,
-			},
-		}
 	}
 
 	// Metrics
@@ -173,23 +222,105 @@ func New(addr string, opts ...Option) (*Server, error) {
 	return server, nil
 }
 
+// IsNamespace tells whether the cache key namespace is used by any of the endpoints
+// (e.g. NamespaceGradle), which is useful to validate the disk's namespace limits.
+func IsNamespace(namespace string) bool {
+	switch namespace {
+	case NamespaceBazelAC, NamespaceBazelCAS, NamespaceGradle:
+		return true
+	default:
+		return false
+	}
+}
+
 func (server *Server) Addr() string {
 	return strings.ReplaceAll(server.listener.Addr().String(), "[::]", "127.0.0.1")
 }
 
+// RegistryMirrorAddrs returns the addresses that the registry mirror endpoints
+// listen on, in the same order as they were specified.
+func (server *Server) RegistryMirrorAddrs() []string {
+	var result []string
+
+	for _, registryMirrorEndpoint := range server.registryMirrorEndpoints {
+		result = append(result, registryMirrorEndpoint.Addr())
+	}
+
+	return result
+}
+
+// BazelAddr returns the address that the Bazel remote cache
+// endpoint listens on, or an empty string when it's not enabled.
+func (server *Server) BazelAddr() string {
+	if server.bazelEndpoint == nil {
+		return ""
+	}
+
+	return server.bazelEndpoint.Addr()
+}
+
+// GradleAddr returns the address that the Gradle build cache
+// endpoint listens on, or an empty string when it's not enabled.
+func (server *Server) GradleAddr() string {
+	if server.gradleEndpoint == nil {
+		return ""
+	}
+
+	return server.gradleEndpoint.Addr()
+}
+
 func (server *Server) Run(ctx context.Context) error {
 	server.logger.Infof("listening on %s", server.Addr())
 
-	go func() {
-		<-ctx.Done()
+	// Serve the additional endpoints alongside the proxy,
+	// stopping everything once any of them fails
+	errCh := make(chan error, 1+len(server.endpoints))
+
+	for _, endpoint := range server.endpoints {
+		server.logger.Infof("serving %s on %s", endpoint.description, endpoint.Addr())
 
-		_ = server.httpServer.Close()
+		go func() {
+			errCh <- endpoint.httpServer.Serve(endpoint.listener)
+		}()
+	}
+
+	go func() {
+		errCh <- server.httpServer.Serve(server.listener)
 	}()
 
-	return server.httpServer.Serve(server.listener)
+	var err error
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+
+	server.backgroundCancel()
+
+	_ = server.httpServer.Close()
+
+	for _, endpoint := range server.endpoints {
+		_ = endpoint.httpServer.Close()
+	}
+
+	if err == nil {
+		err = <-errCh
+	}
+
+	return err
 }
 
 func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.serve(writer, request, server.route)
+}
+
+// serve handles the request using the route, which returns the responder
+// and the operation name, and takes care of the logging and metrics.
+func (server *Server) serve(
+	writer http.ResponseWriter,
+	request *http.Request,
+	route routeFunc,
+) {
 	logger := server.logger.With(
 		"remote_addr", request.RemoteAddr,
 		"host", request.Host,
@@ -202,6 +333,35 @@ func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 	// Capture response writer's status code
 	capturingResponseWriter := capturingresponsewriter.Wrap(writer)
 
+	responder, operation := route(capturingResponseWriter, request)
+
+	responder.Respond(capturingResponseWriter, request)
+
+	logger = logger.With(
+		"status_code", capturingResponseWriter.StatusCode(),
+		"operation", operation,
+	)
+
+	switch {
+	case capturingResponseWriter.StatusCode() >= 400 && capturingResponseWriter.StatusCode() < 500:
+		logger.Warnf("%s", responder.Message())
+	case capturingResponseWriter.StatusCode() >= 500 && capturingResponseWriter.StatusCode() < 600:
+		logger.Errorf("%s", responder.Message())
+	default:
+		logger.Infof("%s", responder.Message())
+	}
+
+	// Metrics
+	//nolint:contextcheck // can's use request.Context() here because it might be canceled
+	server.requestsCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("method", request.Method),
+		attribute.Int("status_code", capturingResponseWriter.StatusCode()),
+		attribute.String("operation", operation),
+	))
+}
+
+// route routes the request received by the proxy endpoint.
+func (server *Server) route(writer http.ResponseWriter, request *http.Request) (responderpkg.Responder, string) {
 	// Default responder
 	var responder responderpkg.Responder
 
@@ -212,53 +372,34 @@ func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 	if request.Host == "" || request.Host == server.Addr() {
 		switch request.Method {
 		case http.MethodPut:
-			responder = server.handleClusterPut(capturingResponseWriter, request)
+			responder = server.handleClusterPut(writer, request)
 			operation = "cluster-put"
+		case http.MethodHead:
+			responder = server.handleClusterHead(writer, request)
+			operation = "cluster-head"
 		case http.MethodGet:
 			switch request.URL.Path {
 			case "/health":
 				responder = responderpkg.NewCodef(http.StatusOK, "healthy")
 				operation = "health-check"
 			case "/direct-connect":
-				responder = server.handleDirectConnectGet(capturingResponseWriter, request)
+				responder = server.handleDirectConnectGet(writer, request)
 				operation = "direct-connect-get"
 			default:
-				responder = server.handleClusterGet(capturingResponseWriter, request)
+				responder = server.handleClusterGet(writer, request)
 				operation = "cluster-get"
 			}
 		}
 	} else {
 		switch request.Method {
 		case http.MethodConnect:
-			responder = server.handleProxyConnect(capturingResponseWriter, request)
+			responder = server.handleProxyConnect(writer, request)
 			operation = "proxy-connect"
 		default:
-			responder = server.handleProxyDefault(capturingResponseWriter, request)
+			responder = server.handleProxyDefault(writer, request)
 			operation = "proxy-default"
 		}
 	}
 
-	responder.Respond(capturingResponseWriter, request)
-
-	logger = logger.With(
-		"status_code", capturingResponseWriter.StatusCode(),
-		"operation", operation,
-	)
-
-	switch {
-	case capturingResponseWriter.StatusCode() >= 400 && capturingResponseWriter.StatusCode() < 500:
-		logger.Warnf("%s", responder.Message())
-	case capturingResponseWriter.StatusCode() >= 500 && capturingResponseWriter.StatusCode() < 600:
-		logger.Errorf("%s", responder.Message())
-	default:
-		logger.Infof("%s", responder.Message())
-	}
-
-	// Metrics
-	//nolint:contextcheck // can's use request.Context() here because it might be canceled
-	server.requestsCounter.Add(context.Background(), 1, metric.WithAttributes(
-		attribute.String("method", request.Method),
-		attribute.Int("status_code", capturingResponseWriter.StatusCode()),
-		attribute.String("operation", operation),
-	))
+	return responder, operation
 }
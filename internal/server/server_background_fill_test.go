@@ -0,0 +1,151 @@
+package server_test
+
+import (
+	"context"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackgroundFill(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		ServerOpts     []server.Option
+		RuleOpts       []rule.Option
+		ExpectedCached bool
+	}{
+		{
+			Name:           "disabled",
+			ExpectedCached: false,
+		},
+		{
+			Name:           "enabled-globally",
+			ServerOpts:     []server.Option{server.WithBackgroundFill(0, time.Minute)},
+			ExpectedCached: true,
+		},
+		{
+			Name:           "enabled-per-rule",
+			RuleOpts:       []rule.Option{rule.WithBackgroundFill(true)},
+			ExpectedCached: true,
+		},
+		{
+			Name:           "disabled-per-rule",
+			ServerOpts:     []server.Option{server.WithBackgroundFill(0, time.Minute)},
+			RuleOpts:       []rule.Option{rule.WithBackgroundFill(false)},
+			ExpectedCached: false,
+		},
+		{
+			Name:           "too-large",
+			ServerOpts:     []server.Option{server.WithBackgroundFill(1024, time.Minute)},
+			ExpectedCached: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// Make the halves large enough to not get stuck in the buffers
+			firstHalf := strings.Repeat("A", 64*1024)
+			secondHalf := strings.Repeat("B", 64*1024)
+
+			// Configure an origin server that only sends the second
+			// half of the response once we allow it to do so
+			secondHalfAllowed := make(chan struct{})
+			upstreamCanceled := make(chan struct{})
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("ETag", `"v1"`)
+				writer.Header().Set("Content-Length", strconv.Itoa(len(firstHalf)+len(secondHalf)))
+
+				_, _ = writer.Write([]byte(firstHalf))
+				writer.(http.Flusher).Flush()
+
+				select {
+				case <-secondHalfAllowed:
+				case <-request.Context().Done():
+					close(upstreamCanceled)
+
+					return
+				}
+
+				_, _ = writer.Write([]byte(secondHalf))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false, testCase.RuleOpts...)
+			require.NoError(t, err)
+
+			opts := append([]server.Option{
+				server.WithDisk(disk),
+				server.WithRules(rule.Rules{catchAllRule}),
+			}, testCase.ServerOpts...)
+
+			addr := chachaServer(t, opts...)
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// Start the fill, receive the first half and go away
+			ctx, cancel := context.WithCancel(context.Background())
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin.URL, nil)
+			require.NoError(t, err)
+
+			resp, err := httpClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			_, err = io.ReadFull(resp.Body, make([]byte, len(firstHalf)))
+			require.NoError(t, err)
+
+			cancel()
+			_ = resp.Body.Close()
+
+			// The fill that can't continue in the background
+			// should be stopped once the client goes away
+			if !testCase.ExpectedCached {
+				select {
+				case <-upstreamCanceled:
+				case <-time.After(10 * time.Second):
+					t.Fatal("upstream request was not canceled after the client went away")
+				}
+			}
+
+			// Allow the fill to finish
+			close(secondHalfAllowed)
+
+			cacheEntryExists := func() bool {
+				var numEntries int
+
+				require.NoError(t, disk.Walk(func(file fs.File, _ diskpkg.Info, err error) error {
+					if err != nil {
+						return err
+					}
+
+					numEntries++
+
+					return file.Close()
+				}))
+
+				return numEntries != 0
+			}
+
+			if testCase.ExpectedCached {
+				require.Eventually(t, cacheEntryExists, 10*time.Second, 10*time.Millisecond)
+			} else {
+				require.Never(t, cacheEntryExists, time.Second, 10*time.Millisecond)
+			}
+		})
+	}
+}
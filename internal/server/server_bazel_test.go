@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBazel(t *testing.T) {
+	const token = "secret-token"
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	chachaServer, err := server.New(":0", server.WithDisk(disk), server.WithBazel("127.0.0.1:0", token))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		_ = chachaServer.Run(ctx)
+	}()
+
+	bazelURL := "http://" + chachaServer.BazelAddr()
+
+	contents := "Hello, World!"
+	contentsHash := sha256.Sum256([]byte(contents))
+	casURL := bazelURL + "/cas/" + hex.EncodeToString(contentsHash[:])
+	acURL := bazelURL + "/prefix/ac/" + hex.EncodeToString(contentsHash[:])
+
+	doRequest := func(method string, url string, body string, token string) (int, string) {
+		request, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+
+		if token != "" {
+			request.SetBasicAuth("bazel", token)
+		}
+
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	// Token is required
+	statusCode, _ := doRequest(http.MethodGet, casURL, "", "")
+	require.Equal(t, http.StatusUnauthorized, statusCode)
+
+	statusCode, _ = doRequest(http.MethodGet, casURL, "", "wrong-token")
+	require.Equal(t, http.StatusUnauthorized, statusCode)
+
+	// CAS entry doesn't exist yet
+	statusCode, _ = doRequest(http.MethodGet, casURL, "", token)
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _ = doRequest(http.MethodHead, casURL, "", token)
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	// CAS entry that doesn't match its hash is rejected
+	statusCode, _ = doRequest(http.MethodPut, casURL, "Goodbye, World!", token)
+	require.Equal(t, http.StatusBadRequest, statusCode)
+
+	statusCode, _ = doRequest(http.MethodGet, casURL, "", token)
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	// CAS entry that matches its hash is stored
+	statusCode, _ = doRequest(http.MethodPut, casURL, contents, token)
+	require.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, body := doRequest(http.MethodGet, casURL, "", token)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, contents, body)
+
+	statusCode, _ = doRequest(http.MethodHead, casURL, "", token)
+	require.Equal(t, http.StatusOK, statusCode)
+
+	// AC entries are not verified and live in a separate namespace from the CAS
+	statusCode, _ = doRequest(http.MethodGet, acURL, "", token)
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _ = doRequest(http.MethodPut, acURL, "action result", token)
+	require.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, body = doRequest(http.MethodGet, acURL, "", token)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "action result", body)
+
+	// Other paths are not served
+	statusCode, _ = doRequest(http.MethodGet, bazelURL+"/cas/not-a-hash", "", token)
+	require.Equal(t, http.StatusNotFound, statusCode)
+}
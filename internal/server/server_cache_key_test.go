@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCacheKeyTemplate(t *testing.T) {
+	const digest = "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+
+	var fullResponses []string
+	var revalidations []string
+	var mtx sync.Mutex
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !strings.HasPrefix(request.URL.Path, "/v2/org/public-") {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		writer.Header().Set("ETag", `"`+digest+`"`)
+		writer.Header().Set("Cache-Control", "max-age=3600")
+
+		if request.Header.Get("If-None-Match") == `"`+digest+`"` {
+			revalidations = append(revalidations, request.URL.Path)
+
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		fullResponses = append(fullResponses, request.URL.Path)
+
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	blobRule, err := rule.New(`/v2/.+/blobs/(?P<digest>sha256:[0-9a-f]{64})$`, false, nil, false, false,
+		rule.WithCacheKey("oci-blob:${digest}"))
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{blobRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	get := func(repository string) (int, string) {
+		resp, err := httpClient.Get(origin.URL + "/v2/org/" + repository + "/blobs/" + digest)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	// The first repository populates the cache entry
+	statusCode, body := get("public-a")
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "Hello, World!", body)
+
+	// The second repository re-uses the cache entry, but it's
+	// still revalidated with the upstream using its own URL
+	statusCode, body = get("public-b")
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "Hello, World!", body)
+
+	// The repository that the client has no access
+	// to should not be served from the cache entry
+	statusCode, _ = get("private")
+	require.Equal(t, http.StatusUnauthorized, statusCode)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	require.Equal(t, []string{"/v2/org/public-a/blobs/" + digest}, fullResponses)
+	require.Equal(t, []string{"/v2/org/public-b/blobs/" + digest}, revalidations)
+}
@@ -0,0 +1,287 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingSpooler is a cache whose spools fail once the given number of bytes is written.
+type failingSpooler struct {
+	limit     int64
+	committed *atomic.Bool
+}
+
+func (failingSpooler) Get(context.Context, string) (io.ReadSeekCloser, cachepkg.Metadata, error) {
+	return nil, cachepkg.Metadata{}, cachepkg.ErrNotFound
+}
+
+func (failingSpooler) Head(context.Context, string) (int64, cachepkg.Metadata, error) {
+	return 0, cachepkg.Metadata{}, cachepkg.ErrNotFound
+}
+
+func (failingSpooler) Put(context.Context, string, cachepkg.Metadata, io.Reader, int64) error {
+	return errors.New("no space left on device")
+}
+
+func (spooler failingSpooler) Spool(context.Context, string, cachepkg.Metadata, int64) (cachepkg.Spool, error) {
+	return &failingSpool{
+		limit:     spooler.limit,
+		committed: spooler.committed,
+	}, nil
+}
+
+type failingSpool struct {
+	limit     int64
+	committed *atomic.Bool
+
+	mtx     sync.Mutex
+	data    []byte
+	written int64
+}
+
+func (spool *failingSpool) ReadAt(p []byte, off int64) (int, error) {
+	spool.mtx.Lock()
+	defer spool.mtx.Unlock()
+
+	if off >= int64(len(spool.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, spool.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (spool *failingSpool) WriteAt(p []byte, off int64) (int, error) {
+	spool.mtx.Lock()
+	defer spool.mtx.Unlock()
+
+	n := min(int64(len(p)), spool.limit-spool.written)
+
+	if end := off + n; end > int64(len(spool.data)) {
+		spool.data = append(spool.data, make([]byte, end-int64(len(spool.data)))...)
+	}
+
+	copy(spool.data[off:], p[:n])
+	spool.written += n
+
+	if n < int64(len(p)) {
+		return int(n), errors.New("no space left on device")
+	}
+
+	return int(n), nil
+}
+
+func (spool *failingSpool) Commit(context.Context, int64) error {
+	spool.committed.Store(true)
+
+	return nil
+}
+
+func (spool *failingSpool) Close() error {
+	return nil
+}
+
+func TestCacheWriteFailure(t *testing.T) {
+	// Use random contents to catch the misplaced contents
+	contents := make([]byte, 48*1024*1024)
+
+	chacha := rand.NewChaCha8([32]byte{})
+	_, _ = chacha.Read(contents)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+
+		http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	t.Cleanup(origin.Close)
+
+	smallDisk, err := diskpkg.New(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name              string
+		Cache             cachepkg.Cache
+		ParallelFetch     uint
+		ExpectedOperation string
+	}{
+		{
+			Name:              "mid-stream",
+			Cache:             failingSpooler{limit: 1024, committed: &atomic.Bool{}},
+			ExpectedOperation: "write-failed",
+		},
+		{
+			Name:              "mid-stream-parallel-fetch",
+			Cache:             failingSpooler{limit: 20 * 1024 * 1024, committed: &atomic.Bool{}},
+			ParallelFetch:     3,
+			ExpectedOperation: "write-failed",
+		},
+		{
+			Name:              "larger-than-disk-limit",
+			Cache:             smallDisk,
+			ExpectedOperation: "too-large",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			failuresBefore := cacheOperations(t, testCase.ExpectedOperation)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithParallelFetch(testCase.ParallelFetch))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(testCase.Cache), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// The client should receive the full contents
+			// despite the cache entry write failure
+			resp, err := httpClient.Get(origin.URL)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			bodyBytes, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(contents, bodyBytes))
+			require.NoError(t, resp.Body.Close())
+
+			// The cache entry write failure should be recorded
+			require.Eventually(t, func() bool {
+				return cacheOperations(t, testCase.ExpectedOperation) > failuresBefore
+			}, 5*time.Second, 10*time.Millisecond)
+
+			if spooler, ok := testCase.Cache.(failingSpooler); ok {
+				require.False(t, spooler.committed.Load())
+			}
+		})
+	}
+}
+
+//nolint:gochecknoglobals // the global meter provider can only be set once
+var metricReader = sync.OnceValue(func() *sdkmetric.ManualReader {
+	metricReader := sdkmetric.NewManualReader()
+
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metricReader)))
+
+	return metricReader
+})
+
+// cacheOperations returns the number of the cache operations of the given type recorded so far.
+func cacheOperations(t *testing.T, operationType string) int64 {
+	t.Helper()
+
+	var resourceMetrics metricdata.ResourceMetrics
+
+	require.NoError(t, metricReader().Collect(context.Background(), &resourceMetrics))
+
+	var result int64
+
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, metrics := range scopeMetrics.Metrics {
+			sum, ok := metrics.Data.(metricdata.Sum[int64])
+			if !ok || metrics.Name != "org.cirruslabs.chacha.cache.operation_count" {
+				continue
+			}
+
+			for _, dataPoint := range sum.DataPoints {
+				if value, ok := dataPoint.Attributes.Value("type"); ok && value.AsString() == operationType {
+					result += dataPoint.Value
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+func TestMaxSize(t *testing.T) {
+	contents := strings.Repeat("A", 64*1024)
+
+	testCases := []struct {
+		Name          string
+		ContentLength bool
+	}{
+		{
+			Name:          "content-length",
+			ContentLength: true,
+		},
+		{
+			Name:          "chunked",
+			ContentLength: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var fullResponses atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("ETag", `"v1"`)
+
+				if request.Header.Get("If-None-Match") == `"v1"` {
+					writer.WriteHeader(http.StatusNotModified)
+
+					return
+				}
+
+				fullResponses.Add(1)
+
+				if testCase.ContentLength {
+					writer.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+				}
+
+				_, _ = writer.Write([]byte(contents))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false, rule.WithMaxSize(1024))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// Objects larger than the rule's max size should
+			// be passed through, but should never be cached
+			for range 2 {
+				resp, err := httpClient.Get(origin.URL)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, contents, string(bodyBytes))
+				require.NoError(t, resp.Body.Close())
+			}
+
+			require.EqualValues(t, 2, fullResponses.Load())
+		})
+	}
+}
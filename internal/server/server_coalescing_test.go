@@ -0,0 +1,197 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescing(t *testing.T) {
+	// Make the halves large enough to not get stuck in the buffers
+	firstHalf := strings.Repeat("A", 64*1024)
+	secondHalf := strings.Repeat("B", 64*1024)
+
+	// Configure an origin server that only sends the second
+	// half of the response once we allow it to do so
+	var fullResponses atomic.Int64
+	var conditionalResponses atomic.Int64
+
+	secondHalfAllowed := make(chan struct{})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			conditionalResponses.Add(1)
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		fullResponses.Add(1)
+
+		writer.Header().Set("Content-Length", strconv.Itoa(len(firstHalf)+len(secondHalf)))
+		_, _ = writer.Write([]byte(firstHalf))
+		writer.(http.Flusher).Flush()
+
+		<-secondHalfAllowed
+
+		_, _ = writer.Write([]byte(secondHalf))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// Start the fill and wait for the first half to arrive
+	firstResp, err := httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, firstResp.StatusCode)
+
+	firstReader := bufio.NewReader(firstResp.Body)
+
+	firstHalfBytes := make([]byte, len(firstHalf))
+	_, err = io.ReadFull(firstReader, firstHalfBytes)
+	require.NoError(t, err)
+	require.Equal(t, firstHalf, string(firstHalfBytes))
+
+	// The concurrent request should join the in-flight fill
+	// and receive the first half without waiting for it to finish
+	secondResp, err := httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, secondResp.StatusCode)
+	require.EqualValues(t, len(firstHalf)+len(secondHalf), secondResp.ContentLength)
+
+	secondReader := bufio.NewReader(secondResp.Body)
+
+	secondFirstHalfBytes := make([]byte, len(firstHalf))
+	_, err = io.ReadFull(secondReader, secondFirstHalfBytes)
+	require.NoError(t, err)
+	require.Equal(t, firstHalf, string(secondFirstHalfBytes))
+
+	// Allow the fill to finish
+	close(secondHalfAllowed)
+
+	for _, reader := range []io.Reader{firstReader, secondReader} {
+		secondHalfBytes, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, secondHalf, string(secondHalfBytes))
+	}
+
+	require.NoError(t, firstResp.Body.Close())
+	require.NoError(t, secondResp.Body.Close())
+
+	// The upstream should've only been asked for the contents once,
+	// with the concurrent request being authorized by the upstream
+	require.EqualValues(t, 1, fullResponses.Load())
+	require.EqualValues(t, 1, conditionalResponses.Load())
+}
+
+func TestCoalescingLeaderGoesAway(t *testing.T) {
+	// Make the halves large enough to not get stuck in the buffers
+	firstHalf := strings.Repeat("A", 64*1024)
+	secondHalf := strings.Repeat("B", 64*1024)
+
+	// Configure an origin server that only sends the second
+	// half of the response once we allow it to do so
+	secondHalfAllowed := make(chan struct{})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		writer.Header().Set("Content-Length", strconv.Itoa(len(firstHalf)+len(secondHalf)))
+		_, _ = writer.Write([]byte(firstHalf))
+		writer.(http.Flusher).Flush()
+
+		<-secondHalfAllowed
+
+		_, _ = writer.Write([]byte(secondHalf))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	// Start the fill and wait for the first half to arrive
+	ctx, cancel := context.WithCancel(context.Background())
+
+	firstReq, err := http.NewRequestWithContext(ctx, http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+
+	firstResp, err := proxiedHTTPClient(t, addr).Do(firstReq)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, firstResp.StatusCode)
+
+	_, err = io.ReadFull(firstResp.Body, make([]byte, len(firstHalf)))
+	require.NoError(t, err)
+
+	// Join the in-flight fill
+	secondResp, err := proxiedHTTPClient(t, addr).Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, secondResp.StatusCode)
+
+	secondReader := bufio.NewReader(secondResp.Body)
+
+	_, err = io.ReadFull(secondReader, make([]byte, len(firstHalf)))
+	require.NoError(t, err)
+
+	// The request that has started the fill goes away,
+	// which shouldn't affect the request that has joined it
+	cancel()
+	_ = firstResp.Body.Close()
+
+	close(secondHalfAllowed)
+
+	secondHalfBytes, err := io.ReadAll(secondReader)
+	require.NoError(t, err)
+	require.Equal(t, secondHalf, string(secondHalfBytes))
+	require.NoError(t, secondResp.Body.Close())
+
+	// The cache entry should be created too
+	require.Eventually(t, func() bool {
+		var numEntries int
+
+		require.NoError(t, disk.Walk(func(file fs.File, _ diskpkg.Info, err error) error {
+			if err != nil {
+				return err
+			}
+
+			numEntries++
+
+			return file.Close()
+		}))
+
+		return numEntries != 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestVerifyDigest(t *testing.T) {
+	contents := strings.Repeat("A", 64*1024)
+	contentsSum := sha256.Sum256([]byte(contents))
+	contentsDigest := "sha256:" + hex.EncodeToString(contentsSum[:])
+	otherDigest := "sha256:" + strings.Repeat("0", 64)
+
+	testCases := []struct {
+		Name                string
+		Path                string
+		DockerContentDigest string
+		ShouldCache         bool
+	}{
+		{
+			Name:        "path-match",
+			Path:        "/v2/org/repo/blobs/" + contentsDigest,
+			ShouldCache: true,
+		},
+		{
+			Name:        "path-mismatch",
+			Path:        "/v2/org/repo/blobs/" + otherDigest,
+			ShouldCache: false,
+		},
+		{
+			Name:                "header-match",
+			Path:                "/v2/org/repo/manifests/latest",
+			DockerContentDigest: contentsDigest,
+			ShouldCache:         true,
+		},
+		{
+			Name:                "header-mismatch",
+			Path:                "/v2/org/repo/blobs/" + contentsDigest,
+			DockerContentDigest: otherDigest,
+			ShouldCache:         false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var fullResponses atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				fullResponses.Add(1)
+
+				if testCase.DockerContentDigest != "" {
+					writer.Header().Set("Docker-Content-Digest", testCase.DockerContentDigest)
+				}
+
+				writer.Header().Set("Cache-Control", "max-age=3600")
+
+				_, _ = writer.Write([]byte(contents))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithVerifyDigest(rule.VerifyDigestFromPath))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// The client should always receive the contents, but only
+			// the contents matching the expected digests should be cached
+			for range 2 {
+				resp, err := httpClient.Get(origin.URL + testCase.Path)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, contents, string(bodyBytes))
+				require.NoError(t, resp.Body.Close())
+			}
+
+			if testCase.ShouldCache {
+				require.EqualValues(t, 1, fullResponses.Load())
+			} else {
+				require.EqualValues(t, 2, fullResponses.Load())
+			}
+		})
+	}
+}
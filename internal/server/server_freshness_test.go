@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	testCases := []struct {
+		name                     string
+		cacheControl             string
+		freshFor                 time.Duration
+		expectedUpstreamRequests int64
+	}{
+		{
+			name:                     "no-freshness",
+			expectedUpstreamRequests: 2,
+		},
+		{
+			name:                     "rule-fresh-for",
+			freshFor:                 time.Hour,
+			expectedUpstreamRequests: 1,
+		},
+		{
+			name:                     "upstream-max-age",
+			cacheControl:             "max-age=3600",
+			expectedUpstreamRequests: 1,
+		},
+		{
+			name:                     "upstream-s-maxage-takes-precedence",
+			cacheControl:             "max-age=3600, s-maxage=0",
+			expectedUpstreamRequests: 2,
+		},
+		{
+			name:                     "upstream-no-cache",
+			cacheControl:             "max-age=3600, no-cache",
+			expectedUpstreamRequests: 2,
+		},
+		{
+			name:                     "rule-fresh-for-takes-precedence",
+			cacheControl:             "max-age=0",
+			freshFor:                 time.Hour,
+			expectedUpstreamRequests: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var upstreamRequests atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				upstreamRequests.Add(1)
+
+				writer.Header().Set("ETag", `"v1"`)
+				if testCase.cacheControl != "" {
+					writer.Header().Set("Cache-Control", testCase.cacheControl)
+				}
+
+				http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader([]byte("Hello, World!")))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithFreshFor(testCase.freshFor))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			for range 2 {
+				resp, err := httpClient.Get(origin.URL)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, "Hello, World!", string(bodyBytes))
+				require.NoError(t, resp.Body.Close())
+			}
+
+			require.Equal(t, testCase.expectedUpstreamRequests, upstreamRequests.Load())
+		})
+	}
+}
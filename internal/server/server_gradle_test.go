@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"context"
+	cachepkg "github.com/cirruslabs/chacha/internal/cache"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGradle(t *testing.T) {
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte,
+		diskpkg.WithNamespaceLimit("gradle", 1*humanize.KByte))
+	require.NoError(t, err)
+
+	chachaServer, err := server.New(":0", server.WithDisk(disk),
+		server.WithGradle("127.0.0.1:0", "gradle", "secret"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		_ = chachaServer.Run(ctx)
+	}()
+
+	cacheURL := "http://" + chachaServer.GradleAddr() + "/cache/"
+
+	doRequest := func(method string, url string, body string, password string) (int, string) {
+		request, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+
+		if password != "" {
+			request.SetBasicAuth("gradle", password)
+		}
+
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	// Credentials are required
+	statusCode, _ := doRequest(http.MethodGet, cacheURL+"0123abcd", "", "")
+	require.Equal(t, http.StatusUnauthorized, statusCode)
+
+	statusCode, _ = doRequest(http.MethodGet, cacheURL+"0123abcd", "", "wrong")
+	require.Equal(t, http.StatusUnauthorized, statusCode)
+
+	// Build cache entry doesn't exist yet
+	statusCode, _ = doRequest(http.MethodGet, cacheURL+"0123abcd", "", "secret")
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _ = doRequest(http.MethodPut, cacheURL+"0123abcd", "build output", "secret")
+	require.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, body := doRequest(http.MethodGet, cacheURL+"0123abcd", "", "secret")
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "build output", body)
+
+	statusCode, _ = doRequest(http.MethodHead, cacheURL+"0123abcd", "", "secret")
+	require.Equal(t, http.StatusOK, statusCode)
+
+	// Build cache can be served under a prefix
+	statusCode, body = doRequest(http.MethodGet, "http://"+chachaServer.GradleAddr()+"/prefix/cache/0123abcd",
+		"", "secret")
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "build output", body)
+
+	// Build cache entries are stored in their own namespace
+	_, _, err = disk.Get(ctx, cachepkg.NamespacedKey("gradle", "0123abcd"))
+	require.NoError(t, err)
+
+	// Other paths and methods are not served
+	statusCode, _ = doRequest(http.MethodGet, cacheURL+"not-a-hash", "", "secret")
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _ = doRequest(http.MethodPost, cacheURL+"0123abcd", "", "secret")
+	require.Equal(t, http.StatusMethodNotAllowed, statusCode)
+
+	// Build cache entries that exceed the namespace's limit are rejected
+	statusCode, _ = doRequest(http.MethodPut, cacheURL+"4567cdef", strings.Repeat("A", 2048), "secret")
+	require.Equal(t, http.StatusRequestEntityTooLarge, statusCode)
+}
@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/capturingresponsewriter"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHead(t *testing.T) {
+	content := []byte("Hello, World!")
+
+	// Configure an origin server that records the status codes it responds with
+	var mtx sync.Mutex
+	var upstreamResponses []string
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+		writer.Header().Set("Content-Type", "application/octet-stream")
+
+		capturingWriter := capturingresponsewriter.Wrap(writer)
+
+		http.ServeContent(capturingWriter, request, "", time.Time{}, bytes.NewReader(content))
+
+		mtx.Lock()
+		upstreamResponses = append(upstreamResponses,
+			request.Method+" "+http.StatusText(capturingWriter.StatusCode()))
+		mtx.Unlock()
+	}))
+	t.Cleanup(origin.Close)
+
+	// Configure Chacha with a disk and a catch-all rule
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// HEAD request without a cache entry should be passed through to the origin
+	resp, err := httpClient.Head(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	// Populate the cache
+	resp, err = httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	// HEAD request with a cache entry should be revalidated
+	// and answered with the cache entry's size
+	resp, err = httpClient.Head(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.EqualValues(t, len(content), resp.ContentLength)
+	require.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, []string{"HEAD OK", "GET OK", "HEAD Not Modified"}, upstreamResponses)
+}
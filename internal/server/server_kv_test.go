@@ -53,7 +53,7 @@ func TestKV(t *testing.T) {
 	firstMetadata := cachepkg.Metadata{ETag: firstETag}
 	firstBlob := []byte("Hello, World!\n")
 
-	err = kv.Put(ctx, key, firstMetadata, bytes.NewReader(firstBlob))
+	err = kv.Put(ctx, key, firstMetadata, bytes.NewReader(firstBlob), -1)
 	require.NoError(t, err)
 
 	// Ensure that a request for an existent key succeeds
@@ -71,7 +71,7 @@ func TestKV(t *testing.T) {
 	secondMetadata := cachepkg.Metadata{ETag: secondETag}
 	secondBlob := []byte("Goodbye, Cruel World!\n")
 
-	err = kv.Put(ctx, key, secondMetadata, bytes.NewReader(secondBlob))
+	err = kv.Put(ctx, key, secondMetadata, bytes.NewReader(secondBlob), -1)
 	require.NoError(t, err)
 
 	// Ensure that the key got overwritten
@@ -84,3 +84,103 @@ func TestKV(t *testing.T) {
 	require.Equal(t, secondMetadata, actualMetadata)
 	require.Equal(t, secondBlob, actualBlob)
 }
+
+func TestKVSeek(t *testing.T) {
+	ctx := context.Background()
+	secret := uuid.NewString()
+	key := uuid.NewString()
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	opts := []server.Option{
+		server.WithDisk(disk),
+		server.WithCluster(
+			cluster.New(
+				secret,
+				"127.0.0.1:8085",
+				[]config.Node{
+					{
+						Addr: "127.0.0.1:8085",
+					},
+				},
+			),
+		),
+	}
+
+	addr := chachaServerWithAddr(t, "127.0.0.1:8085", opts...)
+
+	kv := kvpkg.New(addr, secret)
+
+	err = kv.Put(ctx, key, cachepkg.Metadata{}, bytes.NewReader([]byte("Hello, World!\n")), -1)
+	require.NoError(t, err)
+
+	cacheEntryReader, _, err := kv.Get(ctx, key)
+	require.NoError(t, err)
+
+	// Seeking to the end should yield the size of the cache entry
+	size, err := cacheEntryReader.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, 14, size)
+
+	// Seeking back should re-request the rest of the cache entry from the node
+	_, err = cacheEntryReader.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+
+	actualBlob, err := io.ReadAll(cacheEntryReader)
+	require.NoError(t, err)
+	require.Equal(t, "World!\n", string(actualBlob))
+
+	// Seeking to the beginning should work too
+	_, err = cacheEntryReader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	actualBlob, err = io.ReadAll(cacheEntryReader)
+	require.NoError(t, err)
+	require.Equal(t, "Hello, World!\n", string(actualBlob))
+
+	require.NoError(t, cacheEntryReader.Close())
+}
+
+func TestKVHead(t *testing.T) {
+	ctx := context.Background()
+	secret := uuid.NewString()
+	key := uuid.NewString()
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	opts := []server.Option{
+		server.WithDisk(disk),
+		server.WithCluster(
+			cluster.New(
+				secret,
+				"127.0.0.1:8086",
+				[]config.Node{
+					{
+						Addr: "127.0.0.1:8086",
+					},
+				},
+			),
+		),
+	}
+
+	addr := chachaServerWithAddr(t, "127.0.0.1:8086", opts...)
+
+	kv := kvpkg.New(addr, secret)
+
+	// Ensure that a request for a non-existent key returns an error
+	_, _, err = kv.Head(ctx, key)
+	require.ErrorIs(t, err, cachepkg.ErrNotFound)
+
+	// Ensure that a request for an existent key yields its size and metadata
+	expectedMetadata := cachepkg.Metadata{ETag: uuid.NewString()}
+
+	err = kv.Put(ctx, key, expectedMetadata, bytes.NewReader([]byte("Hello, World!\n")), -1)
+	require.NoError(t, err)
+
+	size, actualMetadata, err := kv.Head(ctx, key)
+	require.NoError(t, err)
+	require.EqualValues(t, 14, size)
+	require.Equal(t, expectedMetadata, actualMetadata)
+}
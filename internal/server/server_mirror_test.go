@@ -0,0 +1,137 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMirrors(t *testing.T) {
+	var originRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		originRequests.Add(1)
+
+		// Origin still receives the credentials meant for it
+		require.Equal(t, "Bearer origin-token", request.Header.Get("Authorization"))
+		require.Equal(t, "session=origin", request.Header.Get("Cookie"))
+
+		writer.Header().Set("ETag", `"v1"`)
+		_, _ = writer.Write([]byte("Hello from the origin!"))
+	}))
+	t.Cleanup(origin.Close)
+
+	// Mirror that is not reachable
+	unreachableMirror := httptest.NewServer(http.NotFoundHandler())
+	unreachableMirror.Close()
+
+	// Mirror that is broken
+	brokenMirror := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(brokenMirror.Close)
+
+	// Mirror that works
+	var mirrorRequests atomic.Int64
+
+	workingMirror := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mirrorRequests.Add(1)
+
+		require.Equal(t, "/docker-hub/v2/library/alpine/blobs/sha256:abc", request.URL.Path)
+		require.Equal(t, "ns=docker.io", request.URL.RawQuery)
+
+		// Credentials meant for the origin should never leak to the mirror
+		require.Empty(t, request.Header.Get("Authorization"))
+		require.Empty(t, request.Header.Get("Cookie"))
+
+		writer.Header().Set("ETag", `"v1"`)
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte("Hello from the mirror!"))
+	}))
+	t.Cleanup(workingMirror.Close)
+
+	const path = "/v2/library/alpine/blobs/sha256:abc?ns=docker.io"
+
+	testCases := []struct {
+		Name           string
+		Mirrors        []string
+		Expected       string
+		MirrorRequests int64
+		OriginRequests int64
+	}{
+		{
+			Name: "failover-to-mirror",
+			Mirrors: []string{
+				unreachableMirror.URL,
+				brokenMirror.URL,
+				workingMirror.URL + "/docker-hub",
+			},
+			Expected:       "Hello from the mirror!",
+			MirrorRequests: 2,
+			OriginRequests: 0,
+		},
+		{
+			Name: "failover-to-origin",
+			Mirrors: []string{
+				unreachableMirror.URL,
+				brokenMirror.URL,
+			},
+			Expected:       "Hello from the origin!",
+			MirrorRequests: 0,
+			OriginRequests: 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			mirrorRequests.Store(0)
+			originRequests.Store(0)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", true, nil, false, false,
+				rule.WithMirrors(testCase.Mirrors))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// The second request is revalidated against the
+			// same upstream and served from the cache entry
+			for range 2 {
+				request, err := http.NewRequest(http.MethodGet, origin.URL+path, nil)
+				require.NoError(t, err)
+
+				request.Header.Set("Authorization", "Bearer origin-token")
+				request.Header.Set("Cookie", "session=origin")
+
+				resp, err := httpClient.Do(request)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, testCase.Expected, string(bodyBytes))
+				require.NoError(t, resp.Body.Close())
+			}
+
+			require.Equal(t, testCase.MirrorRequests, mirrorRequests.Load())
+			require.Equal(t, testCase.OriginRequests, originRequests.Load())
+		})
+	}
+}
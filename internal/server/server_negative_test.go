@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegativeTTL(t *testing.T) {
+	var upstreamRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		upstreamRequests.Add(1)
+
+		writer.Header().Set("Content-Type", "text/plain")
+
+		switch request.URL.Path {
+		case "/gone":
+			writer.WriteHeader(http.StatusGone)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+
+		_, _ = writer.Write([]byte("no such bottle"))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false,
+		rule.WithNegativeTTL(time.Second))
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	get := func(path string) *http.Response {
+		resp, err := httpClient.Get(origin.URL + path)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "no such bottle", string(bodyBytes))
+		require.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+
+		return resp
+	}
+
+	// The negative responses should be served from the cache
+	for _, testCase := range []struct {
+		Path       string
+		StatusCode int
+	}{
+		{Path: "/missing", StatusCode: http.StatusNotFound},
+		{Path: "/gone", StatusCode: http.StatusGone},
+	} {
+		require.Equal(t, testCase.StatusCode, get(testCase.Path).StatusCode)
+		require.Equal(t, testCase.StatusCode, get(testCase.Path).StatusCode)
+	}
+
+	require.EqualValues(t, 2, upstreamRequests.Load())
+
+	// ...but only for the negative TTL
+	time.Sleep(time.Second)
+
+	require.Equal(t, http.StatusNotFound, get("/missing").StatusCode)
+	require.EqualValues(t, 3, upstreamRequests.Load())
+}
+
+func TestNegativeTTLDisabled(t *testing.T) {
+	var upstreamRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		upstreamRequests.Add(1)
+
+		writer.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	for range 2 {
+		resp, err := httpClient.Get(origin.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	require.EqualValues(t, 2, upstreamRequests.Load())
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelFetch(t *testing.T) {
+	// Use random contents to catch the misplaced segments
+	contents := make([]byte, 48*1024*1024)
+
+	chacha := rand.NewChaCha8([32]byte{})
+	_, _ = chacha.Read(contents)
+
+	var fullRequests atomic.Int64
+	var rangeRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Range") != "" {
+			rangeRequests.Add(1)
+		} else if request.Header.Get("If-None-Match") == "" {
+			fullRequests.Add(1)
+		}
+
+		writer.Header().Set("ETag", `"v1"`)
+
+		http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	t.Cleanup(origin.Close)
+
+	// Make sure that the spool files are cleaned up
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false,
+		rule.WithParallelFetch(4))
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// The segments should be written directly into the cache entry,
+	// so there should never be more than a single temporary file
+	var maxTmpFiles atomic.Int64
+
+	samplingCtx, stopSampling := context.WithCancel(context.Background())
+	samplingDone := make(chan struct{})
+
+	go func() {
+		defer close(samplingDone)
+
+		for samplingCtx.Err() == nil {
+			if entries, err := os.ReadDir(tmpDir); err == nil && int64(len(entries)) > maxTmpFiles.Load() {
+				maxTmpFiles.Store(int64(len(entries)))
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for range 2 {
+		resp, err := httpClient.Get(origin.URL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.True(t, bytes.Equal(contents, bodyBytes))
+	}
+
+	stopSampling()
+	<-samplingDone
+
+	require.EqualValues(t, 1, maxTmpFiles.Load())
+
+	// 48 MiB object should be fetched in 3 segments of 16 MiB,
+	// with the first one coming from the original request
+	// and then served from the cache
+	require.EqualValues(t, 1, fullRequests.Load())
+	require.EqualValues(t, 2, rangeRequests.Load())
+
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(tmpDir)
+		require.NoError(t, err)
+
+		return len(entries) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
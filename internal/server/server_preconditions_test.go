@@ -0,0 +1,169 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPreconditions(t *testing.T) {
+	lastModified := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var upstreamValidators []string
+	var mtx sync.Mutex
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Representation that only has the Last-Modified validator
+		if request.URL.Path == "/no-etag" {
+			writer.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+			if request.Header.Get("If-Modified-Since") != "" {
+				writer.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			_, _ = writer.Write([]byte("Hello, World!"))
+
+			return
+		}
+
+		mtx.Lock()
+		upstreamValidators = append(upstreamValidators, request.Header.Get("If-None-Match"))
+		mtx.Unlock()
+
+		writer.Header().Set("ETag", `"v1"`)
+		writer.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	getURL := func(url string, header http.Header) (int, string) {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+
+		request.Header = header
+
+		resp, err := httpClient.Do(request)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	get := func(header http.Header) (int, string) {
+		return getURL(origin.URL, header)
+	}
+
+	// Populate the cache entry
+	statusCode, body := get(http.Header{})
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "Hello, World!", body)
+
+	testCases := []struct {
+		Name               string
+		Header             http.Header
+		ExpectedStatusCode int
+	}{
+		{
+			Name:               "if-none-match-matches",
+			Header:             http.Header{"If-None-Match": {`"v0", W/"v1"`}},
+			ExpectedStatusCode: http.StatusNotModified,
+		},
+		{
+			Name:               "if-none-match-star",
+			Header:             http.Header{"If-None-Match": {"*"}},
+			ExpectedStatusCode: http.StatusNotModified,
+		},
+		{
+			Name:               "if-none-match-differs",
+			Header:             http.Header{"If-None-Match": {`"v0"`}},
+			ExpectedStatusCode: http.StatusOK,
+		},
+		{
+			Name:               "if-modified-since-not-modified",
+			Header:             http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}},
+			ExpectedStatusCode: http.StatusNotModified,
+		},
+		{
+			Name: "if-modified-since-modified",
+			Header: http.Header{"If-Modified-Since": {
+				lastModified.Add(-time.Hour).Format(http.TimeFormat),
+			}},
+			ExpectedStatusCode: http.StatusOK,
+		},
+		{
+			Name: "if-none-match-takes-precedence",
+			Header: http.Header{
+				"If-None-Match":     {`"v0"`},
+				"If-Modified-Since": {lastModified.Format(http.TimeFormat)},
+			},
+			ExpectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			statusCode, body := get(testCase.Header)
+			require.Equal(t, testCase.ExpectedStatusCode, statusCode)
+
+			if testCase.ExpectedStatusCode == http.StatusOK {
+				require.Equal(t, "Hello, World!", body)
+			} else {
+				require.Empty(t, body)
+			}
+		})
+	}
+
+	// "*" matches the cache entry that has no ETag
+	statusCode, body = getURL(origin.URL+"/no-etag", http.Header{})
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "Hello, World!", body)
+
+	statusCode, body = getURL(origin.URL+"/no-etag", http.Header{"If-None-Match": {"*"}})
+	require.Equal(t, http.StatusNotModified, statusCode)
+	require.Empty(t, body)
+
+	statusCode, _ = getURL(origin.URL+"/no-etag", http.Header{"If-None-Match": {`"v1"`}})
+	require.Equal(t, http.StatusOK, statusCode)
+
+	// The cache entry should always be revalidated
+	// using our own validator and not the client's
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	require.Len(t, upstreamValidators, len(testCases)+1)
+	require.Empty(t, upstreamValidators[0])
+
+	for _, upstreamValidator := range upstreamValidators[1:] {
+		require.Equal(t, `"v1"`, upstreamValidator)
+	}
+}
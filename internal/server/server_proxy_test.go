@@ -123,3 +123,16 @@ func chachaServerWithAddr(t *testing.T, addr string, opts ...server.Option) stri
 
 	return chachaServer.Addr()
 }
+
+func proxiedHTTPClient(t *testing.T, addr string) *http.Client {
+	t.Helper()
+
+	chachaServerEndpointURL, err := url.Parse(fmt.Sprintf("http://%s", addr))
+	require.NoError(t, err)
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(chachaServerEndpointURL),
+		},
+	}
+}
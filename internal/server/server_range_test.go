@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRange(t *testing.T) {
+	content := []byte("Hello, World!")
+
+	// Configure an origin server that counts full responses
+	var fullResponses atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+
+		if request.Header.Get("If-None-Match") != `"v1"` {
+			fullResponses.Add(1)
+		}
+
+		http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(origin.Close)
+
+	// Configure Chacha with a disk and a catch-all rule
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// Populate the cache
+	resp, err := httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+	require.EqualValues(t, 1, fullResponses.Load())
+
+	doRange := func(rangeHeader string, ifRange string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", rangeHeader)
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp, bodyBytes
+	}
+
+	// Single range should be served from the cache
+	resp, bodyBytes := doRange("bytes=7-", "")
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "bytes 7-12/13", resp.Header.Get("Content-Range"))
+	require.Equal(t, "World!", string(bodyBytes))
+
+	// Suffix range should be served from the cache
+	resp, bodyBytes = doRange("bytes=-1", `"v1"`)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "!", string(bodyBytes))
+
+	// Mismatched If-Range should result in a full response
+	resp, bodyBytes = doRange("bytes=0-4", `"v0"`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, content, bodyBytes)
+
+	// Unsatisfiable range should result in HTTP 416
+	resp, _ = doRange("bytes=100-", "")
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	require.Equal(t, "bytes */13", resp.Header.Get("Content-Range"))
+
+	// Multiple ranges should result in a multipart response
+	resp, bodyBytes = doRange("bytes=0-4,7-11", "")
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/byteranges", mediaType)
+
+	var parts []string
+
+	multipartReader := multipart.NewReader(bytes.NewReader(bodyBytes), params["boundary"])
+
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		partBytes, err := io.ReadAll(part)
+		require.NoError(t, err)
+
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(partBytes))
+	}
+
+	require.Equal(t, []string{"bytes 0-4/13 Hello", "bytes 7-11/13 World"}, parts)
+
+	// None of the requests above should've resulted in a full upstream response
+	require.EqualValues(t, 1, fullResponses.Load())
+}
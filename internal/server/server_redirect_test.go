@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		FollowRedirects bool
+	}{
+		{
+			Name:            "pass-through",
+			FollowRedirects: false,
+		},
+		{
+			Name:            "follow",
+			FollowRedirects: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var redirects atomic.Int64
+			var fullResponses atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				switch request.URL.Path {
+				case "/blob":
+					// Pre-signed URL is different each time
+					signature := strconv.FormatInt(redirects.Add(1), 10)
+
+					http.Redirect(writer, request, "/storage?signature="+signature, http.StatusTemporaryRedirect)
+				case "/storage":
+					writer.Header().Set("ETag", `"v1"`)
+
+					if request.Header.Get("If-None-Match") == `"v1"` {
+						writer.WriteHeader(http.StatusNotModified)
+
+						return
+					}
+
+					fullResponses.Add(1)
+
+					_, _ = writer.Write([]byte("Hello, World!"))
+				default:
+					writer.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			blobRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithFollowRedirects(testCase.FollowRedirects))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{blobRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+			httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+
+			for range 2 {
+				resp, err := httpClient.Get(origin.URL + "/blob")
+				require.NoError(t, err)
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				if testCase.FollowRedirects {
+					require.Equal(t, http.StatusOK, resp.StatusCode)
+					require.Equal(t, "Hello, World!", string(bodyBytes))
+				} else {
+					require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+				}
+			}
+
+			// The original URL should be requested each time,
+			// however, the final response should only be
+			// fetched once when following the redirects
+			require.EqualValues(t, 2, redirects.Load())
+
+			if testCase.FollowRedirects {
+				require.EqualValues(t, 1, fullResponses.Load())
+			} else {
+				require.EqualValues(t, 0, fullResponses.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package server_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/registrymirror"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistryMirror(t *testing.T) {
+	const blob = "Hello, World!"
+
+	blobDigest := sha256.Sum256([]byte(blob))
+	blobPath := "/v2/library/alpine/blobs/sha256:" + hex.EncodeToString(blobDigest[:])
+
+	// Blob storage that the registry redirects to
+	var storageFullResponses atomic.Int64
+
+	storage := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Registry's token should never leak to the blob storage
+		require.Empty(t, request.Header.Get("Authorization"))
+
+		writer.Header().Set("ETag", `"v1"`)
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		storageFullResponses.Add(1)
+
+		_, _ = writer.Write([]byte(blob))
+	}))
+	t.Cleanup(storage.Close)
+
+	// Registry that requires a bearer token even for anonymous pulls
+	var tokenRequests atomic.Int64
+
+	registry := httptest.NewUnstartedServer(nil)
+
+	registry.Config.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/token":
+			tokenRequests.Add(1)
+
+			_ = json.NewEncoder(writer).Encode(map[string]any{
+				"token": "anonymous-token",
+			})
+		case blobPath:
+			if request.Header.Get("Authorization") != "Bearer anonymous-token" {
+				writer.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.URL+`/token",`+
+					`service="registry.test",scope="repository:library/alpine:pull"`)
+				writer.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			http.Redirect(writer, request, storage.URL+"/blob", http.StatusTemporaryRedirect)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	})
+	registry.Start()
+	t.Cleanup(registry.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	// Rules match the upstream registry's URLs
+	blobsRule, err := rule.New("^"+registry.URL+"/v2/.+/blobs/sha256:[0-9a-f]{64}$", false, nil, false, false)
+	require.NoError(t, err)
+
+	registryMirror, err := registrymirror.New("127.0.0.1:0", registry.URL)
+	require.NoError(t, err)
+
+	chachaServer, err := server.New(":0", server.WithDisk(disk), server.WithRules(rule.Rules{blobsRule}),
+		server.WithRegistryMirrors(registryMirror))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		_ = chachaServer.Run(ctx)
+	}()
+
+	registryMirrorURL := "http://" + chachaServer.RegistryMirrorAddrs()[0]
+
+	// API version check
+	resp, err := http.Get(registryMirrorURL + "/v2/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "registry/2.0", resp.Header.Get("Docker-Distribution-API-Version"))
+	require.NoError(t, resp.Body.Close())
+
+	// Blob pulls need neither a proxy nor the client's authentication,
+	// with the second pull being served from the cache entry
+	for range 2 {
+		resp, err := http.Get(registryMirrorURL + blobPath)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, blob, string(bodyBytes))
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.EqualValues(t, 1, storageFullResponses.Load())
+	require.EqualValues(t, 1, tokenRequests.Load())
+
+	// Only pulls are supported
+	resp, err = http.Post(registryMirrorURL+blobPath, "application/octet-stream", strings.NewReader(blob))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	resp, err = http.Get(registryMirrorURL + "/v2/_catalog")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+}
@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOnlyIfCached(t *testing.T) {
+	var upstreamRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		upstreamRequests.Add(1)
+
+		writer.Header().Set("ETag", `"v1"`)
+
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	get := func(cacheControl string) (*http.Response, string) {
+		request, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+
+		if cacheControl != "" {
+			request.Header.Set("Cache-Control", cacheControl)
+		}
+
+		resp, err := httpClient.Do(request)
+		require.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp, string(bodyBytes)
+	}
+
+	// There's no cache entry yet
+	resp, _ := get("only-if-cached")
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	// Populate the cache entry
+	resp, _ = get("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The cache entry is stale, since the upstream
+	// didn't provide any freshness information
+	resp, _ = get("only-if-cached")
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	// ...but the client is willing to accept it anyway
+	resp, body := get("only-if-cached, max-stale")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("X-Chacha-Stale"))
+	require.Equal(t, "Hello, World!", body)
+
+	require.EqualValues(t, 1, upstreamRequests.Load())
+}
+
+func TestNoCache(t *testing.T) {
+	var version atomic.Int64
+
+	version.Store(1)
+
+	var unconditionalRequests atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		eTag := `"v` + strconv.FormatInt(version.Load(), 10) + `"`
+
+		writer.Header().Set("ETag", eTag)
+		writer.Header().Set("Cache-Control", "max-age=3600")
+
+		if request.Header.Get("If-None-Match") == "" {
+			unconditionalRequests.Add(1)
+		} else if request.Header.Get("If-None-Match") == eTag {
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(eTag))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	get := func(header http.Header) string {
+		request, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+
+		request.Header = header
+
+		resp, err := httpClient.Do(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return string(bodyBytes)
+	}
+
+	// Populate the cache entry
+	require.Equal(t, `"v1"`, get(http.Header{}))
+
+	// The upstream has changed the contents, but the cache entry is still fresh
+	version.Store(2)
+
+	require.Equal(t, `"v1"`, get(http.Header{}))
+
+	// The client forces a re-fetch, which replaces the cache entry
+	require.Equal(t, `"v2"`, get(http.Header{"Cache-Control": {"no-cache"}}))
+	require.Equal(t, `"v2"`, get(http.Header{}))
+
+	// Pragma is also honored in the absence of Cache-Control
+	version.Store(3)
+
+	require.Equal(t, `"v3"`, get(http.Header{"Pragma": {"no-cache"}}))
+	require.Equal(t, `"v3"`, get(http.Header{}))
+
+	require.EqualValues(t, 3, unconditionalRequests.Load())
+}
@@ -0,0 +1,117 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	contents := strings.Repeat("A", 128*1024) + strings.Repeat("B", 128*1024)
+
+	testCases := []struct {
+		Name           string
+		ResumeAttempts uint
+		ShouldResume   bool
+	}{
+		{
+			Name:           "disabled",
+			ResumeAttempts: 0,
+			ShouldResume:   false,
+		},
+		{
+			Name:           "enabled",
+			ResumeAttempts: 3,
+			ShouldResume:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var interrupted atomic.Bool
+			var fullResponses atomic.Int64
+			var rangeRequests atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("ETag", `"v1"`)
+
+				if request.Header.Get("Range") != "" {
+					rangeRequests.Add(1)
+				} else if request.Header.Get("If-None-Match") == "" {
+					fullResponses.Add(1)
+				}
+
+				// Break the connection in the middle of the first download
+				if !interrupted.Swap(true) {
+					writer.Header().Set("Accept-Ranges", "bytes")
+					writer.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+					writer.WriteHeader(http.StatusOK)
+
+					_, _ = writer.Write([]byte(contents[:len(contents)/2]))
+					writer.(http.Flusher).Flush()
+
+					conn, _, err := writer.(http.Hijacker).Hijack()
+					require.NoError(t, err)
+					require.NoError(t, conn.Close())
+
+					return
+				}
+
+				http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader([]byte(contents)))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithResumeAttempts(testCase.ResumeAttempts))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			resp, err := httpClient.Get(origin.URL)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			bodyBytes, err := io.ReadAll(resp.Body)
+			require.NoError(t, resp.Body.Close())
+
+			if !testCase.ShouldResume {
+				require.Error(t, err)
+				require.EqualValues(t, 0, rangeRequests.Load())
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, contents, string(bodyBytes))
+			require.EqualValues(t, 1, rangeRequests.Load())
+
+			// The resumed download should result in a complete cache entry
+			resp, err = httpClient.Get(origin.URL)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			bodyBytes, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, contents, string(bodyBytes))
+
+			require.EqualValues(t, 1, fullResponses.Load())
+		})
+	}
+}
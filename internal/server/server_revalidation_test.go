@@ -0,0 +1,131 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRevalidationLastModified(t *testing.T) {
+	lastModified := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// Configure an origin server that only provides Last-Modified
+	var fullResponses atomic.Int64
+
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("If-Modified-Since") == "" {
+			fullResponses.Add(1)
+		}
+
+		http.ServeContent(writer, request, "", lastModified, bytes.NewReader([]byte("Hello, World!")))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// Populate the cache and then retrieve from it
+	for range 2 {
+		resp, err := httpClient.Get(origin.URL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, World!", string(bodyBytes))
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.EqualValues(t, 1, fullResponses.Load())
+
+	// If-Range with a matching HTTP-date should be honored
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=7-")
+	req.Header.Set("If-Range", lastModified.Format(http.TimeFormat))
+
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "World!", string(bodyBytes))
+	require.NoError(t, resp.Body.Close())
+
+	require.EqualValues(t, 1, fullResponses.Load())
+}
+
+func TestRevalidationReplaysHeaders(t *testing.T) {
+	// Configure an origin server that responds with 304 Not Modified,
+	// which lacks most of the headers of the original 200 OK response
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+		writer.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		writer.Header().Set("Content-Disposition", `attachment; filename="manifest.json"`)
+		writer.Header().Set("Docker-Content-Digest", "sha256:cafebabe")
+
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.Header().Del("Content-Type")
+			writer.Header().Del("Content-Disposition")
+			writer.Header().Del("Docker-Content-Digest")
+			writer.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		writer.Header().Set("Set-Cookie", "session=secret")
+
+		_, _ = writer.Write([]byte("{}"))
+	}))
+	t.Cleanup(origin.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// Populate the cache
+	resp, err := httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	// Retrieve from the cache, the original headers should be replayed
+	resp, err = httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(bodyBytes))
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	require.Equal(t, "application/vnd.oci.image.manifest.v1+json", resp.Header.Get("Content-Type"))
+	require.Equal(t, `attachment; filename="manifest.json"`, resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "sha256:cafebabe", resp.Header.Get("Docker-Content-Digest"))
+	require.EqualValues(t, 2, resp.ContentLength)
+	require.Empty(t, resp.Header.Get("Set-Cookie"))
+}
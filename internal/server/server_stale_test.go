@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"bytes"
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleIfError(t *testing.T) {
+	testCases := []struct {
+		name               string
+		cacheControl       string
+		requestDirective   string
+		staleIfError       time.Duration
+		closeOrigin        bool
+		expectedStatusCode int
+	}{
+		{
+			name:               "no-stale-if-error",
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:               "rule-stale-if-error",
+			staleIfError:       time.Hour,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "rule-stale-if-error-connection-error",
+			staleIfError:       time.Hour,
+			closeOrigin:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "no-stale-if-error-connection-error",
+			closeOrigin:        true,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "upstream-stale-if-error",
+			cacheControl:       "stale-if-error=3600",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "request-stale-if-error",
+			requestDirective:   "stale-if-error=3600",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "request-max-stale",
+			requestDirective:   "max-stale",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "request-max-stale-exceeded",
+			requestDirective:   "max-stale=0",
+			closeOrigin:        true,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "request-no-cache",
+			requestDirective:   "no-cache, stale-if-error=3600",
+			staleIfError:       time.Hour,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var failing atomic.Bool
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if failing.Load() {
+					writer.WriteHeader(http.StatusServiceUnavailable)
+
+					return
+				}
+
+				writer.Header().Set("ETag", `"v1"`)
+				if testCase.cacheControl != "" {
+					writer.Header().Set("Cache-Control", testCase.cacheControl)
+				}
+
+				http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader([]byte("Hello, World!")))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false,
+				rule.WithStaleIfError(testCase.staleIfError))
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// Populate the cache
+			resp, err := httpClient.Get(origin.URL)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+
+			// Break the origin
+			if testCase.closeOrigin {
+				origin.Close()
+			} else {
+				failing.Store(true)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+			require.NoError(t, err)
+			if testCase.requestDirective != "" {
+				req.Header.Set("Cache-Control", testCase.requestDirective)
+			}
+
+			resp, err = httpClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedStatusCode, resp.StatusCode)
+
+			if testCase.expectedStatusCode == http.StatusOK {
+				require.Equal(t, "1", resp.Header.Get("X-Chacha-Stale"))
+				require.Equal(t, `111 - "Revalidation Failed"`, resp.Header.Get("Warning"))
+
+				bodyBytes, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, "Hello, World!", string(bodyBytes))
+			}
+
+			require.NoError(t, resp.Body.Close())
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/cirruslabs/chacha/internal/server/upstream"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamResponseHeaderTimeout(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Hang the response
+		select {
+		case <-request.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(origin.Close)
+
+	// The rule's settings take precedence over the global ones
+	catchAllRule, err := rule.New(".*", false, nil, false, false,
+		rule.WithUpstream(upstream.Settings{ResponseHeaderTimeout: 100 * time.Millisecond}))
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithRules(rule.Rules{catchAllRule}),
+		server.WithUpstream(upstream.Settings{ResponseHeaderTimeout: time.Hour}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// Hung upstream should not hang the client
+	startedAt := time.Now()
+
+	resp, err := httpClient.Get(origin.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Less(t, time.Since(startedAt), 5*time.Second)
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	var proxiedRequests atomic.Int64
+
+	parentProxy := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		proxiedRequests.Add(1)
+
+		require.Equal(t, "http://origin.test/file.txt", request.RequestURI)
+
+		writer.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(parentProxy.Close)
+
+	disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+	require.NoError(t, err)
+
+	catchAllRule, err := rule.New(".*", false, nil, false, false)
+	require.NoError(t, err)
+
+	addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}),
+		server.WithUpstream(upstream.Settings{Proxy: parentProxy.URL}))
+
+	httpClient := proxiedHTTPClient(t, addr)
+
+	// The second request should be served from the cache
+	for range 2 {
+		resp, err := httpClient.Get("http://origin.test/file.txt")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, World!", string(bodyBytes))
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.EqualValues(t, 1, proxiedRequests.Load())
+}
+
+func TestUpstreamProxyInvalid(t *testing.T) {
+	_, err := server.New("127.0.0.1:0", server.WithUpstream(upstream.Settings{Proxy: "ftp://proxy.example.com"}))
+	require.Error(t, err)
+}
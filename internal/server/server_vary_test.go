@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	diskpkg "github.com/cirruslabs/chacha/internal/cache/disk"
+	"github.com/cirruslabs/chacha/internal/server"
+	"github.com/cirruslabs/chacha/internal/server/rule"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestVary(t *testing.T) {
+	testCases := []struct {
+		Name                  string
+		Vary                  string
+		ExpectedFullResponses int64
+	}{
+		{
+			Name:                  "vary-origin",
+			Vary:                  "Origin",
+			ExpectedFullResponses: 2,
+		},
+		{
+			Name:                  "vary-asterisk",
+			Vary:                  "*",
+			ExpectedFullResponses: 4,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// Configure an origin server that responds
+			// with a different content for each Origin
+			var fullResponses atomic.Int64
+
+			origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				eTag := `"` + request.Header.Get("Origin") + `"`
+
+				writer.Header().Set("ETag", eTag)
+				writer.Header().Set("Vary", testCase.Vary)
+
+				if request.Header.Get("If-None-Match") == eTag {
+					writer.WriteHeader(http.StatusNotModified)
+
+					return
+				}
+
+				fullResponses.Add(1)
+
+				_, _ = writer.Write([]byte("Hello, " + request.Header.Get("Origin") + "!"))
+			}))
+			t.Cleanup(origin.Close)
+
+			disk, err := diskpkg.New(t.TempDir(), 1*humanize.GByte)
+			require.NoError(t, err)
+
+			catchAllRule, err := rule.New(".*", false, nil, false, false)
+			require.NoError(t, err)
+
+			addr := chachaServer(t, server.WithDisk(disk), server.WithRules(rule.Rules{catchAllRule}))
+
+			httpClient := proxiedHTTPClient(t, addr)
+
+			// Populate the cache with both variants and then retrieve them
+			for range 2 {
+				for _, requestOrigin := range []string{"https://a.example.com", "https://b.example.com"} {
+					req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+					require.NoError(t, err)
+					req.Header.Set("Origin", requestOrigin)
+
+					resp, err := httpClient.Do(req)
+					require.NoError(t, err)
+					require.Equal(t, http.StatusOK, resp.StatusCode)
+
+					bodyBytes, err := io.ReadAll(resp.Body)
+					require.NoError(t, err)
+					require.Equal(t, "Hello, "+requestOrigin+"!", string(bodyBytes))
+					require.NoError(t, resp.Body.Close())
+				}
+			}
+
+			require.Equal(t, testCase.ExpectedFullResponses, fullResponses.Load())
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var ErrIdleReadTimeout = errors.New("no data was received from the upstream for too long")
+
+// idleTimeoutTransport limits the time for which reading
+// the response body can block waiting for the upstream.
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (transport *idleTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = newIdleTimeoutBody(response.Body, transport.timeout)
+
+	return response, nil
+}
+
+// idleTimeoutBody closes the underlying body when a single read
+// blocks for too long, which unblocks that read with an error.
+//
+// The timer only runs during the reads, so that a slow consumer
+// of the body doesn't cause the timeout.
+type idleTimeoutBody struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	idleTimeoutBody := &idleTimeoutBody{
+		body:    body,
+		timeout: timeout,
+	}
+
+	idleTimeoutBody.timer = time.AfterFunc(timeout, func() {
+		idleTimeoutBody.timedOut.Store(true)
+
+		_ = body.Close()
+	})
+	idleTimeoutBody.timer.Stop()
+
+	return idleTimeoutBody
+}
+
+func (body *idleTimeoutBody) Read(p []byte) (int, error) {
+	body.timer.Reset(body.timeout)
+	n, err := body.body.Read(p)
+	body.timer.Stop()
+
+	if err != nil && body.timedOut.Load() {
+		return n, fmt.Errorf("%w (%v): %v", ErrIdleReadTimeout, body.timeout, err)
+	}
+
+	return n, err
+}
+
+func (body *idleTimeoutBody) Close() error {
+	body.timer.Stop()
+
+	return body.body.Close()
+}
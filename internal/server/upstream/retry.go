@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"github.com/cenkalti/backoff/v4"
+	"io"
+	"net/http"
+	"time"
+)
+
+// retryTransport retries the idempotent requests that fail before the upstream's
+// response body is read, either due to a connection error or a temporary server
+// error, with an exponential backoff between the attempts.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries uint
+}
+
+func (transport *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !isRetryable(request) {
+		return transport.base.RoundTrip(request)
+	}
+
+	exponentialBackOff := backoff.NewExponentialBackOff()
+
+	for attempt := uint(0); ; attempt++ {
+		response, err := transport.base.RoundTrip(request)
+
+		if attempt == transport.retries || request.Context().Err() != nil || !shouldRetry(response, err) {
+			return response, err
+		}
+
+		if response != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+			_ = response.Body.Close()
+		}
+
+		select {
+		case <-time.After(exponentialBackOff.NextBackOff()):
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+	}
+}
+
+// isRetryable determines whether the request can be safely re-sent, which is the
+// case for idempotent requests without a body, see RFC 9110 "HTTP Semantics",
+// §9.2.2 "Idempotent Methods"[1].
+//
+// [1]: https://datatracker.ietf.org/doc/html/rfc9110#section-9.2.2
+func isRetryable(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+
+	return request.Body == nil || request.Body == http.NoBody
+}
+
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+// Package upstream implements the HTTP transport used to
+// perform requests to the upstream with configurable
+// timeouts and retries.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type DialContextFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+// Settings configure the upstream requests, with zero values meaning no limit (timeouts),
+// no retries or no proxy. Settings are comparable, so that they can be used as a map key.
+type Settings struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleReadTimeout       time.Duration
+	Retries               uint
+
+	// Proxy is the URL of the parent proxy (e.g. "http://proxy.example.com:3128"
+	// or "socks5://proxy.example.com:1080") to perform the upstream requests through
+	Proxy string
+
+	// NoProxy is the comma-separated list of hosts to contact directly,
+	// in the same format as the NO_PROXY environment variable
+	NoProxy string
+}
+
+// Validate checks whether the settings can be used to create a transport.
+func (settings Settings) Validate() error {
+	if settings.Proxy == "" {
+		return nil
+	}
+
+	proxyURL, err := url.Parse(settings.Proxy)
+	if err != nil {
+		return fmt.Errorf("failed to parse proxy URL %q: %w", settings.Proxy, err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	default:
+		return fmt.Errorf("proxy URL %q should use either http, https, socks5 or socks5h scheme",
+			settings.Proxy)
+	}
+}
+
+// Merge returns the settings with the override's non-zero values taking precedence.
+func (settings Settings) Merge(override Settings) Settings {
+	if override.DialTimeout != 0 {
+		settings.DialTimeout = override.DialTimeout
+	}
+
+	if override.TLSHandshakeTimeout != 0 {
+		settings.TLSHandshakeTimeout = override.TLSHandshakeTimeout
+	}
+
+	if override.ResponseHeaderTimeout != 0 {
+		settings.ResponseHeaderTimeout = override.ResponseHeaderTimeout
+	}
+
+	if override.IdleReadTimeout != 0 {
+		settings.IdleReadTimeout = override.IdleReadTimeout
+	}
+
+	if override.Retries != 0 {
+		settings.Retries = override.Retries
+	}
+
+	if override.Proxy != "" {
+		settings.Proxy = override.Proxy
+	}
+
+	if override.NoProxy != "" {
+		settings.NoProxy = override.NoProxy
+	}
+
+	return settings
+}
+
+// NewTransport creates an HTTP transport that performs the upstream requests according
+// to the settings, with the dialContext being an optional custom dialer function.
+func NewTransport(settings Settings, dialContext DialContextFunc) http.RoundTripper {
+	if dialContext == nil {
+		dialContext = (&net.Dialer{}).DialContext
+	}
+
+	if dialTimeout := settings.DialTimeout; dialTimeout != 0 {
+		baseDialContext := dialContext
+
+		dialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+
+			return baseDialContext(ctx, network, addr)
+		}
+	}
+
+	httpTransport := &http.Transport{
+		DialContext:           dialContext,
+		TLSHandshakeTimeout:   settings.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: settings.ResponseHeaderTimeout,
+		DisableCompression:    true,
+	}
+
+	if settings.Proxy != "" {
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  settings.Proxy,
+			HTTPSProxy: settings.Proxy,
+			NoProxy:    settings.NoProxy,
+		}).ProxyFunc()
+
+		httpTransport.Proxy = func(request *http.Request) (*url.URL, error) {
+			return proxyFunc(request.URL)
+		}
+	}
+
+	var transport http.RoundTripper = httpTransport
+
+	if settings.IdleReadTimeout != 0 {
+		transport = &idleTimeoutTransport{
+			base:    transport,
+			timeout: settings.IdleReadTimeout,
+		}
+	}
+
+	if settings.Retries != 0 {
+		transport = &retryTransport{
+			base:    transport,
+			retries: settings.Retries,
+		}
+	}
+
+	return transport
+}
@@ -0,0 +1,134 @@
+package upstream_test
+
+import (
+	"github.com/cirruslabs/chacha/internal/server/upstream"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	global := upstream.Settings{
+		DialTimeout:         30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		Retries:             3,
+	}
+
+	require.Equal(t, upstream.Settings{
+		DialTimeout:         5 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleReadTimeout:     time.Minute,
+		Retries:             3,
+	}, global.Merge(upstream.Settings{
+		DialTimeout:     5 * time.Second,
+		IdleReadTimeout: time.Minute,
+	}))
+
+	require.Equal(t, global, global.Merge(upstream.Settings{}))
+}
+
+func TestRetries(t *testing.T) {
+	var attempts atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient := &http.Client{
+		Transport: upstream.NewTransport(upstream.Settings{Retries: 3}, nil),
+	}
+
+	resp, err := httpClient.Get(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+	require.EqualValues(t, 3, attempts.Load())
+
+	// Non-idempotent requests are never retried
+	attempts.Store(0)
+
+	resp, err = httpClient.Post(server.URL, "text/plain", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+	require.EqualValues(t, 1, attempts.Load())
+}
+
+func TestIdleReadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("Hello, "))
+		writer.(http.Flusher).Flush()
+
+		// Hang the response
+		select {
+		case <-request.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient := &http.Client{
+		Transport: upstream.NewTransport(upstream.Settings{IdleReadTimeout: 100 * time.Millisecond}, nil),
+	}
+
+	resp, err := httpClient.Get(server.URL)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorIs(t, err, upstream.ErrIdleReadTimeout)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestProxy(t *testing.T) {
+	var proxiedRequests atomic.Int64
+
+	parentProxy := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		proxiedRequests.Add(1)
+
+		// Proxies receive the requests in the absolute form
+		require.Equal(t, "http://origin.test/file.txt", request.RequestURI)
+
+		_, _ = writer.Write([]byte("Hello, World!"))
+	}))
+	t.Cleanup(parentProxy.Close)
+
+	httpClient := &http.Client{
+		Transport: upstream.NewTransport(upstream.Settings{
+			Proxy:   parentProxy.URL,
+			NoProxy: ".internal.test",
+		}, nil),
+	}
+
+	resp, err := httpClient.Get("http://origin.test/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello, World!", string(bodyBytes))
+	require.NoError(t, resp.Body.Close())
+	require.EqualValues(t, 1, proxiedRequests.Load())
+
+	// Hosts matching the exclusions are contacted directly
+	_, err = httpClient.Get("http://origin.internal.test/file.txt")
+	require.Error(t, err)
+	require.EqualValues(t, 1, proxiedRequests.Load())
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, upstream.Settings{}.Validate())
+	require.NoError(t, upstream.Settings{Proxy: "http://proxy.example.com:3128"}.Validate())
+	require.NoError(t, upstream.Settings{Proxy: "socks5://proxy.example.com:1080"}.Validate())
+	require.Error(t, upstream.Settings{Proxy: "ftp://proxy.example.com"}.Validate())
+}